@@ -3,11 +3,14 @@ module github.com/rhysd/dotfiles
 go 1.19
 
 require (
+	filippo.io/age v1.2.1
+	github.com/BurntSushi/toml v1.4.0
 	github.com/alecthomas/kingpin/v2 v2.4.0
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/fatih/color v1.18.0
 	github.com/rhysd/abspath v0.0.0-20200817132137-9532ba017882
 	github.com/rhysd/go-github-selfupdate v1.2.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
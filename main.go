@@ -11,33 +11,91 @@ import (
 )
 
 var (
-	cli = kingpin.New("dotfiles", "A dotfiles symlinks manager")
+	cli       = kingpin.New("dotfiles", "A dotfiles symlinks manager")
+	format    = cli.Flag("format", "Output format of link, plan, apply, list, status, clean and restore: text or json").Default("text").Enum("text", "json")
+	noHooks   = cli.Flag("no-hooks", "Do not run hook scripts in .dotfiles/hooks").Bool()
+	home      = cli.Flag("home", "Alternate home directory which '~' in destinations is expanded to on link, list, status, clean and restore").String()
+	root      = cli.Flag("root", "Directory prefixed to all destinations on link, list, status, clean and restore. Links point to paths seen from it").String()
+	noDefault = cli.Flag("no-default", "Do not use built-in default mappings. Only mappings in .dotfiles are used").Bool()
 
-	clone      = cli.Command("clone", "Clone remote repository")
-	cloneRepo  = clone.Arg("repository", "Repository.  Format: 'user', 'user/repo-name', 'git@somewhere.com:repo.git, 'https://somewhere.com/repo.git'").Required().String()
-	clonePath  = clone.Arg("path", "Path where repository cloned").String()
-	cloneHTTPS = clone.Flag("https", "Use https:// instead of git@ protocol for `git clone`.").Short('h').Bool()
+	clone                  = cli.Command("clone", "Clone remote repository")
+	cloneRepo              = clone.Arg("repository", "Repository.  Format: 'user', 'user/repo-name', 'git@somewhere.com:repo.git, 'https://somewhere.com/repo.git'").Required().String()
+	clonePath              = clone.Arg("path", "Path where repository cloned").String()
+	cloneHTTPS             = clone.Flag("https", "Use https:// instead of git@ protocol for `git clone`.").Short('h').Bool()
+	cloneBranch            = clone.Flag("branch", "Branch or tag name to check out").Short('b').String()
+	cloneDepth             = clone.Flag("depth", "Create a shallow clone with the number of commits").Int()
+	cloneRecurseSubmodules = clone.Flag("recurse-submodules", "Initialize submodules in the repository").Bool()
+	cloneOrigin            = clone.Flag("origin", "Name of the remote instead of 'origin'").Short('o').String()
+	cloneLink              = clone.Flag("link", "Put symlinks after cloning successfully").Bool()
+
+	initCmd   = cli.Command("init", "Create a new dotfiles repository")
+	initPath  = initCmd.Arg("path", "Path where repository is created.  If omitted, $DOTFILES_REPO_PATH is used and fallback into the current directory.").String()
+	initScan  = initCmd.Flag("scan", "Add mappings for files in your home directory which are known by default mappings").Bool()
+	initAdopt = initCmd.Flag("adopt", "Move the scanned files into the repository and link them back. It implies --scan").Bool()
 
 	link          = cli.Command("link", "Put symlinks to setup your configurations")
-	linkDryRun    = link.Flag("dry", "Show what happens only").Bool()
+	linkDryRun    = link.Flag("dry", "Show the plan only. Nothing is changed on filesystem").Bool()
+	linkRelative  = link.Flag("relative", "Put relative symbolic links instead of absolute ones").Bool()
+	linkKeepGoing = link.Flag("keep-going", "Continue on failures and report all of them at the end instead of rolling back all changes").Bool()
+	linkConflict  = link.Flag("conflict", "How to handle a file which already exists at destination: skip, backup, overwrite, adopt or ask").Default("skip").Enum("skip", "backup", "overwrite", "adopt", "ask")
 	linkRepo      = link.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
 	linkSpecified = link.Arg("files", "Files to link. If you specify no file, all will be linked.").Strings()
-	// TODO link_no_default = link.Flag("no-default", "Link files specified by mappings.json and mappings_*.json")
+
+	plan          = cli.Command("plan", "Show operations to put symlinks without touching filesystem")
+	planOut       = plan.Flag("out", "Write the plan to the file in JSON to apply it later").Short('o').String()
+	planRelative  = plan.Flag("relative", "Put relative symbolic links instead of absolute ones").Bool()
+	planConflict  = plan.Flag("conflict", "How to handle a file which already exists at destination: skip, backup, overwrite, adopt or ask").Default("skip").Enum("skip", "backup", "overwrite", "adopt", "ask")
+	planRepo      = plan.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
+	planSpecified = plan.Arg("files", "Files to plan. If you specify no file, all will be planned.").Strings()
+
+	apply          = cli.Command("apply", "Apply the plan written by 'plan --out'")
+	applyKeepGoing = apply.Flag("keep-going", "Continue on failures and report all of them at the end instead of rolling back all changes").Bool()
+	applyFile      = apply.Arg("file", "Plan file to apply").Required().String()
+
+	add         = cli.Command("add", "Move files into your dotfiles repository, add mappings for them and link them back")
+	addRepo     = add.Flag("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
+	addAs       = add.Flag("as", "Path of the file in your dotfiles repository. By default it is derived from the file name (e.g. ~/.vimrc -> vimrc)").String()
+	addPlatform = add.Flag("platform", "Add mappings to platform specific mappings file such as mappings_darwin.json").String()
+	addHost     = add.Flag("host", "Add mappings to host specific mappings file such as mappings_host_myhost.json").String()
+	addPaths    = add.Arg("paths", "Files or directories to add").Required().Strings()
 
 	list     = cli.Command("list", "Show a list of symbolic link put by this command")
 	listRepo = list.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
 
+	status     = cli.Command("status", "Show status of every mapping and fail when some of them are out of sync")
+	statusRepo = status.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
+
 	clean     = cli.Command("clean", "Remove all symbolic links put by this command")
 	cleanRepo = clean.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
 
-	update     = cli.Command("update", "Update your dotfiles repository")
-	updateRepo = update.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
+	restore          = cli.Command("restore", "Remove symbolic links put by this command and restore files backed up on linking")
+	restoreDryRun    = restore.Flag("dry", "Show what happens only").Bool()
+	restoreRepo      = restore.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
+	restoreSpecified = restore.Arg("destinations", "Destination paths to restore. If you specify no path, all will be restored.").Strings()
+
+	encrypt           = cli.Command("encrypt", "Encrypt a secret file with age to put it in your dotfiles repository")
+	encryptFile       = encrypt.Arg("file", "File to encrypt").Required().String()
+	encryptOutput     = encrypt.Flag("output", "Path to the encrypted file. Default is '{file}.age'").Short('o').String()
+	encryptRecipients = encrypt.Flag("recipient", "Additional age public key to encrypt for. Identities in the key file are always recipients").Short('r').Strings()
+	encryptArmor      = encrypt.Flag("armor", "Output PEM-encoded text instead of binary").Short('a').Bool()
+
+	decrypt       = cli.Command("decrypt", "Decrypt a secret file encrypted with age")
+	decryptFile   = decrypt.Arg("file", "File to decrypt").Required().String()
+	decryptOutput = decrypt.Flag("output", "Path to the decrypted file. It must be outside the repository. Default is stdout").Short('o').String()
+
+	update        = cli.Command("update", "Update your dotfiles repository")
+	updateLinkSet bool
+	updateLink    = update.Flag("link", "Link mappings added and unlink mappings removed by pulling. Default is 'update_link' in .dotfiles/config.json").IsSetByUser(&updateLinkSet).Bool()
+	updateRepo    = update.Arg("repo", "Path to your dotfiles repository.  If omitted, $DOTFILES_REPO_PATH is searched and fallback into the current directory.").String()
 
 	version    = cli.Command("version", "Show version")
 	updateSelf = cli.Command("selfupdate", "Update the executable binary by downloading the latest version from GitHub releases page.")
 )
 
 func exit(err error) {
+	if e := dotfiles.FlushOutput(err); e != nil && err == nil {
+		err = e
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
 		// Note: Exit code is detemined with looking http://tldp.org/LDP/abs/html/exitcodes.html
@@ -64,17 +122,67 @@ func selfUpdate() {
 }
 
 func main() {
-	switch kingpin.MustParse(cli.Parse(os.Args[1:])) {
+	cmd := kingpin.MustParse(cli.Parse(os.Args[1:]))
+	dotfiles.SetOutputFormat(dotfiles.OutputFormat(*format))
+	dotfiles.SetHooksEnabled(!*noHooks)
+	dotfiles.SetDefaultMappingsEnabled(!*noDefault)
+	if err := dotfiles.SetTarget(*home, *root); err != nil {
+		exit(err)
+	}
+
+	switch cmd {
 	case clone.FullCommand():
-		exit(dotfiles.Clone(*cloneRepo, *clonePath, *cloneHTTPS))
+		exit(dotfiles.Clone(*cloneRepo, *clonePath, dotfiles.CloneOptions{
+			HTTPS:             *cloneHTTPS,
+			Branch:            *cloneBranch,
+			Depth:             *cloneDepth,
+			RecurseSubmodules: *cloneRecurseSubmodules,
+			Origin:            *cloneOrigin,
+			Link:              *cloneLink,
+		}))
+	case initCmd.FullCommand():
+		exit(dotfiles.Init(*initPath, dotfiles.InitOptions{
+			Scan:  *initScan,
+			Adopt: *initAdopt,
+		}))
 	case link.FullCommand():
-		exit(dotfiles.Link(*linkRepo, *linkSpecified, *linkDryRun))
+		exit(dotfiles.Link(*linkRepo, *linkSpecified, dotfiles.LinkOptions{
+			Dry:       *linkDryRun,
+			Conflict:  dotfiles.ConflictStrategy(*linkConflict),
+			Relative:  *linkRelative,
+			KeepGoing: *linkKeepGoing,
+		}))
+	case plan.FullCommand():
+		exit(dotfiles.Plan(*planRepo, *planSpecified, *planOut, dotfiles.LinkOptions{
+			Conflict: dotfiles.ConflictStrategy(*planConflict),
+			Relative: *planRelative,
+		}))
+	case apply.FullCommand():
+		exit(dotfiles.Apply(*applyFile, *applyKeepGoing))
+	case add.FullCommand():
+		exit(dotfiles.Add(*addRepo, *addPaths, dotfiles.AddOptions{
+			As:       *addAs,
+			Platform: *addPlatform,
+			Host:     *addHost,
+		}))
 	case list.FullCommand():
 		exit(dotfiles.List(*listRepo))
+	case status.FullCommand():
+		exit(dotfiles.Status(*statusRepo))
 	case clean.FullCommand():
 		exit(dotfiles.Clean(*cleanRepo))
+	case restore.FullCommand():
+		exit(dotfiles.Restore(*restoreRepo, *restoreSpecified, *restoreDryRun))
+	case encrypt.FullCommand():
+		exit(dotfiles.Encrypt(*encryptFile, *encryptOutput, *encryptRecipients, *encryptArmor))
+	case decrypt.FullCommand():
+		exit(dotfiles.Decrypt(*decryptFile, *decryptOutput))
 	case update.FullCommand():
-		exit(dotfiles.Update(*updateRepo))
+		opts := dotfiles.UpdateOptions{}
+		if updateLinkSet {
+			opts.Link = updateLink
+		}
+		exit(dotfiles.Update(*updateRepo, opts))
 	case version.FullCommand():
 		fmt.Println(dotfiles.Version())
 	case updateSelf.FullCommand():
@@ -0,0 +1,230 @@
+package dotfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/rhysd/abspath"
+)
+
+// AddOptions is a set of options to add files into dotfiles repository
+type AddOptions struct {
+	// As is a path of the file in dotfiles repository. It is derived from the original path when empty
+	As string
+	// Platform is a platform name of mappings file where mappings are added (e.g. mappings_darwin.json)
+	Platform string
+	// Host is a host name of mappings file where mappings are added (e.g. mappings_host_foo.json)
+	Host string
+}
+
+func (opts AddOptions) mappingsFileName() (string, error) {
+	switch {
+	case opts.Platform != "" && opts.Host != "":
+		return "", fmt.Errorf("only one of platform and host can be specified")
+	case opts.Platform != "":
+		return "mappings_" + opts.Platform, nil
+	case opts.Host != "":
+		return "mappings_host_" + opts.Host, nil
+	default:
+		return "mappings", nil
+	}
+}
+
+// adder adds files into dotfiles repository. All changes are rolled back when some of them fails.
+type adder struct {
+	repo     abspath.AbsPath
+	file     abspath.AbsPath
+	content  []byte
+	keys     mappingValues
+	manifest *Manifest
+	actions  []Action
+	undo     []func() error
+}
+
+func (a *adder) rollback() {
+	for i := len(a.undo) - 1; i >= 0; i-- {
+		if err := a.undo[i](); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not roll back: %s\n", err)
+		}
+	}
+	a.undo = nil
+}
+
+// nameFor derives the name of the file in dotfiles repository like '~/.vimrc' -> 'vimrc'
+func nameFor(p abspath.AbsPath) string {
+	b := p.Base().String()
+	if n := strings.TrimLeft(b, "."); n != "" {
+		return n
+	}
+	return b
+}
+
+// destinationFor returns the destination of mapping. A path in home directory starts with '~'.
+func destinationFor(p abspath.AbsPath) string {
+	home, err := abspath.ExpandFromSlash("~")
+	if err == nil && isInside(p.String(), home.String()) {
+		if rel, err := filepath.Rel(home.String(), p.String()); err == nil {
+			return "~/" + filepath.ToSlash(rel)
+		}
+	}
+	return p.ToSlash()
+}
+
+func (a *adder) add(path, as string) error {
+	src, err := abspath.ExpandFrom(path)
+	if err != nil {
+		return err
+	}
+
+	s, err := os.Lstat(src.String())
+	if err != nil {
+		return fmt.Errorf("'%s' does not exist", src.String())
+	}
+	if s.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("'%s' is a symbolic link. It may be already linked", src.String())
+	}
+	if isInside(src.String(), a.repo.String()) {
+		return fmt.Errorf("'%s' is already in dotfiles repository '%s'", src.String(), a.repo.String())
+	}
+
+	key := as
+	if key == "" {
+		key = nameFor(src)
+	}
+	key = filepath.ToSlash(key)
+	if _, ok := a.keys[key]; ok {
+		return fmt.Errorf("'%s' is already mapped in '%s'", key, a.file.String())
+	}
+
+	to := a.repo.Join(filepath.FromSlash(key))
+	if _, err := os.Lstat(to.String()); err == nil {
+		return fmt.Errorf("'%s' already exists in dotfiles repository", to.String())
+	}
+
+	content, err := insertMapping(a.file, a.content, key, destinationFor(src))
+	if err != nil {
+		return &MappingsFileError{File: a.file.String(), Err: err}
+	}
+
+	if err := os.MkdirAll(to.Dir().String(), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	if err := moveFile(src.String(), to.String()); err != nil {
+		return err
+	}
+	a.undo = append(a.undo, func() error { return moveFile(to.String(), src.String()) })
+
+	if err := os.MkdirAll(a.file.Dir().String(), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(a.file.String(), content, 0644); err != nil {
+		return err
+	}
+	a.content = content
+	a.keys[key] = nil
+
+	if err := os.Symlink(to.String(), src.String()); err != nil {
+		return err
+	}
+	a.undo = append(a.undo, func() error { return os.Remove(src.String()) })
+
+	mapping := a.file.Base().String()
+	a.manifest.Add(ManifestEntry{Source: to.String(), Destination: src.String(), Mapping: mapping})
+	a.actions = append(a.actions,
+		Action{Kind: "add", Source: src.String(), Destination: to.String(), Mapping: mapping, Result: ResultDone},
+		Action{Kind: "link", Source: to.String(), Destination: src.String(), Mapping: mapping, Result: ResultDone},
+	)
+
+	return nil
+}
+
+// newAdder creates an adder which adds mappings to the mappings file. The mappings file is restored on
+// rollback.
+func newAdder(repo, file abspath.AbsPath) (*adder, error) {
+	original, err := ioutil.ReadFile(file.String())
+	existed := err == nil
+
+	keys, err := parseMappingsFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = mappingValues{}
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &adder{repo: repo, file: file, content: original, keys: keys, manifest: manifest}
+	a.undo = append(a.undo, func() error {
+		if !existed {
+			return os.RemoveAll(file.String())
+		}
+		return ioutil.WriteFile(file.String(), original, 0644)
+	})
+
+	return a, nil
+}
+
+// finish saves the manifest and reports what was done. Nothing is reported until all files are added
+// successfully.
+func (a *adder) finish() error {
+	if err := a.manifest.Save(); err != nil {
+		a.rollback()
+		return err
+	}
+
+	for _, act := range a.actions {
+		if act.Kind == "add" {
+			output.action(act, color.New(color.FgGreen), "Add:   '%s' -> '%s' (%s)\n", act.Source, act.Destination, act.Mapping)
+		} else {
+			output.action(act, color.New(color.FgCyan), "Link:  '%s' -> '%s'\n", act.Source, act.Destination)
+		}
+	}
+
+	return nil
+}
+
+// Add moves files into dotfiles repository, adds mappings for them to mappings file and puts symbolic
+// links to them at their original places.
+func Add(repoInput string, paths []string, opts AddOptions) error {
+	repo, err := absolutePathToRepo(repoInput)
+	if err != nil {
+		return err
+	}
+
+	if opts.As != "" && len(paths) > 1 {
+		return fmt.Errorf("name in repository cannot be specified for multiple files")
+	}
+
+	name, err := opts.mappingsFileName()
+	if err != nil {
+		return err
+	}
+
+	dir := repo.Join(".dotfiles")
+	file, ok := findMappingsFile(dir, name)
+	if !ok {
+		file = dir.Join(name + ".json")
+	}
+
+	a, err := newAdder(repo, file)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := a.add(p, opts.As); err != nil {
+			a.rollback()
+			return err
+		}
+	}
+
+	return a.finish()
+}
@@ -0,0 +1,199 @@
+package dotfiles
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rhysd/abspath"
+)
+
+func TestNameFor(t *testing.T) {
+	for path, want := range map[string]string{
+		"/home/foo/.vimrc":       "vimrc",
+		"/home/foo/.config/nvim": "nvim",
+		"/home/foo/gitconfig":    "gitconfig",
+		"/home/foo/...":          "...",
+	} {
+		p, err := abspath.ExpandFrom(path)
+		if err != nil {
+			panic(err)
+		}
+		if have := nameFor(p); have != want {
+			t.Errorf("Name for '%s' should be '%s' but got '%s'", path, want, have)
+		}
+	}
+}
+
+func TestInsertMapping(t *testing.T) {
+	for _, tc := range []struct {
+		file  string
+		input string
+		want  string
+	}{
+		{"mappings.json", "", "{\n  \"vimrc\": \"~/.vimrc\"\n}\n"},
+		{"mappings.json", "{}", "{\n  \"vimrc\": \"~/.vimrc\"\n}"},
+		{
+			"mappings.json",
+			"{\n    \"zshrc\": \"~/.zshrc\"\n}\n",
+			"{\n    \"zshrc\": \"~/.zshrc\",\n    \"vimrc\": \"~/.vimrc\"\n}\n",
+		},
+		{
+			"mappings.yaml",
+			"# comment\nzshrc: ~/.zshrc\n",
+			"# comment\nzshrc: ~/.zshrc\nvimrc: ~/.vimrc\n",
+		},
+		{"mappings.yaml", "", "vimrc: ~/.vimrc\n"},
+		{
+			"mappings.toml",
+			"# comment\nzshrc = \"~/.zshrc\"",
+			"# comment\nzshrc = \"~/.zshrc\"\n\"vimrc\" = \"~/.vimrc\"\n",
+		},
+	} {
+		f, err := abspath.ExpandFrom("/path/to/" + tc.file)
+		if err != nil {
+			panic(err)
+		}
+		have, err := insertMapping(f, []byte(tc.input), "vimrc", "~/.vimrc")
+		if err != nil {
+			t.Errorf("Inserting mapping into %s %q caused an error: %s", tc.file, tc.input, err)
+			continue
+		}
+		if string(have) != tc.want {
+			t.Errorf("Inserting mapping into %s %q: wanted %q but got %q", tc.file, tc.input, tc.want, string(have))
+		}
+	}
+}
+
+func TestInsertMappingInvalidFile(t *testing.T) {
+	for file, input := range map[string]string{
+		"mappings.json": "[]",
+		"mappings.yaml": "- foo\n",
+		"mappings.toml": "[table]\n",
+	} {
+		f, err := abspath.ExpandFrom("/path/to/" + file)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := insertMapping(f, []byte(input), "vimrc", "~/.vimrc"); err == nil {
+			t.Errorf("Inserting mapping into %s %q should cause an error", file, input)
+		}
+	}
+}
+
+func TestAddFiles(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+	home := tempDir()
+	defer os.RemoveAll(home.String())
+
+	if err := os.Mkdir(repo.Join(".dotfiles").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(repo.Join(".dotfiles", "mappings.json").String(), "{\n  \"zshrc\": \"~/.zshrc\"\n}\n")
+	writeFile(home.Join(".vimrc").String(), "set number")
+	if err := os.MkdirAll(home.Join(".config", "nvim").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(home.Join(".config", "nvim", "init.vim").String(), "")
+
+	paths := []string{home.Join(".vimrc").String(), home.Join(".config", "nvim").String()}
+	if err := Add(repo.String(), paths, AddOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if readFile(repo.Join("vimrc").String()) != "set number" {
+		t.Fatalf("File should be moved into repository")
+	}
+	if s, err := os.Stat(repo.Join("nvim", "init.vim").String()); err != nil || s.IsDir() {
+		t.Fatalf("Directory should be moved into repository: %v", err)
+	}
+	for _, p := range paths {
+		s, err := readLink(p)
+		if err != nil || !isInside(s, repo.String()) {
+			t.Errorf("'%s' should be linked to repository: %v", p, err)
+		}
+	}
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds := m["vimrc"]; len(ds) != 1 || ds[0].Path.String() != paths[0] {
+		t.Errorf("Mapping for vimrc should be added: %v", ds)
+	}
+	if ds := m["nvim"]; len(ds) != 1 || ds[0].Path.String() != paths[1] {
+		t.Errorf("Mapping for nvim should be added: %v", ds)
+	}
+	if _, ok := m["zshrc"]; !ok {
+		t.Errorf("Existing mapping should be kept: %v", m)
+	}
+}
+
+func TestAddFileToPlatformMappingsAs(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+	home := tempDir()
+	defer os.RemoveAll(home.String())
+	writeFile(home.Join(".vimrc").String(), "")
+
+	if err := Add(repo.String(), []string{home.Join(".vimrc").String()}, AddOptions{As: "vim/vimrc", Platform: "darwin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(repo.Join("vim", "vimrc").String()); err != nil {
+		t.Fatalf("File should be moved to the specified path: %s", err)
+	}
+	vals, err := parseMappingsFile(repo.Join(".dotfiles", "mappings_darwin.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs := vals["vim/vimrc"]; len(vs) != 1 || vs[0].Dst != home.Join(".vimrc").ToSlash() {
+		t.Fatalf("Mapping should be added to platform specific mappings file: %v", vals)
+	}
+}
+
+func TestAddRollback(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+	home := tempDir()
+	defer os.RemoveAll(home.String())
+	writeFile(home.Join(".vimrc").String(), "set number")
+
+	paths := []string{home.Join(".vimrc").String(), home.Join(".not_exist").String()}
+	if err := Add(repo.String(), paths, AddOptions{}); err == nil {
+		t.Fatalf("Adding file which does not exist should cause an error")
+	}
+
+	if s, err := os.Lstat(paths[0]); err != nil || s.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("File should be moved back: %v", err)
+	}
+	if readFile(paths[0]) != "set number" {
+		t.Fatalf("Content should be restored")
+	}
+	if _, err := os.Stat(repo.Join("vimrc").String()); err == nil {
+		t.Fatalf("File in repository should be removed")
+	}
+	if _, err := os.Stat(repo.Join(".dotfiles", "mappings.json").String()); err == nil {
+		t.Fatalf("Mappings file created by add should be removed")
+	}
+}
+
+func TestAddInvalidOptions(t *testing.T) {
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+
+	for _, opts := range []AddOptions{
+		{Platform: "darwin", Host: "foo"},
+		{As: "foo"},
+	} {
+		if err := Add(repo.String(), []string{"/path/to/a", "/path/to/b"}, opts); err == nil {
+			t.Errorf("Options %+v should cause an error", opts)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package dotfiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/rhysd/abspath"
+)
+
+func readPlanFile(file string) (*LinkPlan, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &LinkPlan{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(p); err != nil {
+		return nil, fmt.Errorf("broken plan file '%s': %s", file, err)
+	}
+	if p.Repository == "" {
+		return nil, fmt.Errorf("repository is not specified in plan file '%s'", file)
+	}
+
+	return p, nil
+}
+
+// Apply applies the plan written by Plan in order. The home directory and the root directory on planning
+// are used instead of the current ones. When keepGoing is true, failures are reported at the end instead of
+// rolling back all changes.
+func Apply(file string, keepGoing bool) error {
+	p, err := readPlanFile(file)
+	if err != nil {
+		return err
+	}
+
+	repo, err := abspath.New(p.Repository)
+	if err != nil {
+		return err
+	}
+
+	if err := SetTarget(p.Home, p.Root); err != nil {
+		return err
+	}
+
+	if err := runHook(repo, HookPreLink); err != nil {
+		return err
+	}
+
+	l, err := newLinker(repo, LinkOptions{KeepGoing: keepGoing})
+	if err != nil {
+		return err
+	}
+	if err := l.apply(p); err != nil {
+		return err
+	}
+
+	return runHook(repo, HookPostLink)
+}
@@ -11,5 +11,13 @@ func Clean(repoInput string) error {
 		return err
 	}
 
-	return m.UnlinkAll(repo)
+	if err := runHook(repo, HookPreClean); err != nil {
+		return err
+	}
+
+	if err := m.UnlinkAll(repo); err != nil {
+		return err
+	}
+
+	return runHook(repo, HookPostClean)
 }
@@ -4,11 +4,35 @@ import (
 	"fmt"
 )
 
-func Clone(spec, specified string, https bool) error {
-	repo, err := NewRepository(spec, specified, https)
+// CloneOptions is a set of options to clone dotfiles repository
+type CloneOptions struct {
+	// HTTPS uses https:// instead of git@ protocol
+	HTTPS bool
+	// Branch is a branch or tag name to check out
+	Branch string
+	// Depth is a depth of shallow clone. Full history is cloned when zero
+	Depth int
+	// RecurseSubmodules initializes submodules after cloning
+	RecurseSubmodules bool
+	// Origin is a name of the remote instead of "origin"
+	Origin string
+	// Link puts links after cloning successfully
+	Link bool
+}
+
+func Clone(spec, specified string, opts CloneOptions) error {
+	if opts.Depth < 0 {
+		return fmt.Errorf("depth of clone must not be negative: %d", opts.Depth)
+	}
+
+	repo, err := NewRepository(spec, specified, opts.HTTPS)
 	if err != nil {
 		return err
 	}
+	repo.Branch = opts.Branch
+	repo.Depth = opts.Depth
+	repo.RecurseSubmodules = opts.RecurseSubmodules
+	repo.Origin = opts.Origin
 
 	err = repo.Clone()
 	if err != nil {
@@ -21,5 +45,15 @@ func Clone(spec, specified string, https bool) error {
 	}
 	fmt.Printf("\nYour dotfiles was successfully cloned from '%s' %s '%s'\n", repo.URL, s, repo.Path.String())
 
-	return nil
+	dir := repo.clonedDir()
+	if err := runHook(dir, HookPostClone); err != nil {
+		return err
+	}
+
+	if !opts.Link {
+		return nil
+	}
+
+	fmt.Println()
+	return Link(dir.String(), nil, LinkOptions{})
 }
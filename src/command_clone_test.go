@@ -2,11 +2,12 @@ package dotfiles
 
 import (
 	"os"
+	"os/exec"
 	"testing"
 )
 
 func TestCloneCommand(t *testing.T) {
-	if err := Clone("rhysd/vim-rustpeg", "", true); err != nil {
+	if err := Clone("rhysd/vim-rustpeg", "", CloneOptions{HTTPS: true}); err != nil {
 		t.Fatalf("Unexpected error on cloning: %s", err.Error())
 	}
 	defer os.RemoveAll("vim-rustpeg")
@@ -18,3 +19,40 @@ func TestCloneCommand(t *testing.T) {
 		t.Fatalf("Cloned repository is not a directory")
 	}
 }
+
+func TestCloneNegativeDepth(t *testing.T) {
+	if err := Clone("rhysd/vim-rustpeg", "", CloneOptions{Depth: -1}); err == nil {
+		t.Fatalf("Negative depth should cause an error")
+	}
+}
+
+func TestCloneLocalRepositoryAndLink(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	remote, home := createTestRepo(map[string]string{"_a.conf": ""}, map[string]string{"_a.conf": "a.conf"})
+	defer os.RemoveAll(remote.String())
+	defer os.RemoveAll(home.String())
+	dir := tempDir()
+	defer os.RemoveAll(dir.String())
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = remote.String()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			panic(string(out))
+		}
+	}
+
+	url := "file://" + remote.ToSlash()
+	if err := Clone(url, dir.String(), CloneOptions{Depth: 1, Link: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cloned := dir.Join(remote.Base().String())
+	if s, err := readLink(home.Join("a.conf").String()); err != nil || s != cloned.Join("_a.conf").String() {
+		t.Fatalf("Link should be put after cloning: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package dotfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/rhysd/abspath"
+)
+
+// InitOptions is a set of options to initialize a new dotfiles repository
+type InitOptions struct {
+	// Scan adds mappings for files in home directory which are known by default mappings
+	Scan bool
+	// Adopt moves the scanned files into the repository and links them back. It implies Scan
+	Adopt bool
+}
+
+// foundFile is a file in home directory which is known by default mappings
+type foundFile struct {
+	key  string
+	path abspath.AbsPath
+}
+
+func preferredKey(k, than string) bool {
+	d, t := strings.HasPrefix(k, "."), strings.HasPrefix(than, ".")
+	if d != t {
+		return !d
+	}
+	return k < than
+}
+
+// scanHome finds files in home directory which match destinations of default mappings. When multiple keys
+// are mapped to the same destination, a key without leading dot is preferred. Symbolic links are ignored
+// since they may be already linked. Files inside other found directories are also ignored.
+func scanHome(home abspath.AbsPath, platform string) []foundFile {
+	keys := map[string]string{}
+	for _, name := range []string{unixLikePlatformName, platform} {
+		if name == unixLikePlatformName && !isUnixLikePlatform(platform) {
+			continue
+		}
+		for k, dsts := range defaultMappings[name] {
+			for _, d := range dsts {
+				if prev, ok := keys[d]; !ok || preferredKey(k, prev) {
+					keys[d] = k
+				}
+			}
+		}
+	}
+
+	dsts := make([]string, 0, len(keys))
+	for d := range keys {
+		dsts = append(dsts, d)
+	}
+	sort.Strings(dsts)
+
+	found := []foundFile{}
+	for _, d := range dsts {
+		p := home.Join(filepath.FromSlash(strings.TrimPrefix(d, "~/")))
+		s, err := os.Lstat(p.String())
+		if err != nil || s.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		if !insideFound(p, found) {
+			found = append(found, foundFile{keys[d], p})
+		}
+	}
+
+	return found
+}
+
+// insideFound returns whether the path is inside some found directory. Since destinations are sorted,
+// a parent directory is always found before its children.
+func insideFound(p abspath.AbsPath, found []foundFile) bool {
+	for _, f := range found {
+		if isInside(p.String(), f.path.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func pathToInitRepo(specified string) (abspath.AbsPath, error) {
+	if specified == "" {
+		specified = os.Getenv("DOTFILES_REPO_PATH")
+	}
+	if specified == "" {
+		specified = "."
+	}
+	return abspath.ExpandFrom(specified)
+}
+
+func gitInit(repo abspath.AbsPath) error {
+	if _, err := os.Stat(repo.Join(".git").String()); err == nil {
+		return nil
+	}
+
+	cmd := exec.Command(gitExecutable(os.Getenv("DOTFILES_GIT_COMMAND")), "init", repo.String())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// Init creates a new dotfiles repository with .dotfiles/mappings.json. When some files in home directory
+// are known by default mappings, mappings for them can be added and they can be moved into the repository.
+func Init(specified string, opts InitOptions) error {
+	repo, err := pathToInitRepo(specified)
+	if err != nil {
+		return err
+	}
+
+	dir := repo.Join(".dotfiles")
+	if file, ok := findMappingsFile(dir, "mappings"); ok {
+		return fmt.Errorf("dotfiles repository is already initialized. '%s' exists", file.String())
+	}
+
+	if err := os.MkdirAll(dir.String(), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	if err := gitInit(repo); err != nil {
+		return err
+	}
+
+	found := []foundFile{}
+	if opts.Scan || opts.Adopt {
+		home, err := abspath.ExpandFromSlash("~")
+		if err != nil {
+			return err
+		}
+		found = scanHome(home, runtime.GOOS)
+	}
+
+	file := dir.Join("mappings.json")
+
+	if opts.Adopt {
+		a, err := newAdder(repo, file)
+		if err != nil {
+			return err
+		}
+		for _, f := range found {
+			if err := a.add(f.path.String(), f.key); err != nil {
+				a.rollback()
+				return err
+			}
+		}
+		if len(found) == 0 {
+			if err := ioutil.WriteFile(file.String(), []byte("{\n}\n"), 0644); err != nil {
+				return err
+			}
+		}
+		if err := a.finish(); err != nil {
+			return err
+		}
+	} else {
+		var content []byte
+		for _, f := range found {
+			content, err = insertMapping(file, content, f.key, destinationFor(f.path))
+			if err != nil {
+				return err
+			}
+		}
+		if len(found) == 0 {
+			content = []byte("{\n}\n")
+		}
+		if err := ioutil.WriteFile(file.String(), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\nYour dotfiles repository was successfully initialized at '%s'\n", repo.String())
+	return nil
+}
@@ -0,0 +1,62 @@
+package dotfiles
+
+import (
+	"os"
+	"testing"
+)
+
+func TestScanHome(t *testing.T) {
+	home := tempDir()
+	defer os.RemoveAll(home.String())
+
+	writeFile(home.Join(".vimrc").String(), "")
+	writeFile(home.Join(".zshrc").String(), "")
+	if err := os.MkdirAll(home.Join(".emacs.d").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(home.Join(".emacs.d", "init.el").String(), "")
+	if err := os.Symlink(home.Join(".zshrc").String(), home.Join(".bashrc").String()); err != nil {
+		panic(err)
+	}
+
+	found := scanHome(home, "linux")
+
+	want := map[string]string{
+		"emacs.d": home.Join(".emacs.d").String(),
+		"vimrc":   home.Join(".vimrc").String(),
+		"zshrc":   home.Join(".zshrc").String(),
+	}
+	if len(found) != len(want) {
+		t.Fatalf("Wanted %d files but got %+v", len(want), found)
+	}
+	for _, f := range found {
+		if want[f.key] != f.path.String() {
+			t.Errorf("Unexpected file was found: %s -> %s", f.key, f.path.String())
+		}
+	}
+}
+
+func TestInitRepository(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir.String())
+	repo := dir.Join("dotfiles")
+
+	if err := Init(repo.String(), InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := os.Stat(repo.Join(".git").String()); err != nil || !s.IsDir() {
+		t.Fatalf("Git repository should be initialized: %v", err)
+	}
+	m, err := parseMappingsFile(repo.Join(".dotfiles", "mappings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 0 {
+		t.Fatalf("Mappings file should be empty: %v", m)
+	}
+
+	if err := Init(repo.String(), InitOptions{}); err == nil {
+		t.Fatalf("Initializing repository twice should cause an error")
+	}
+}
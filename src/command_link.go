@@ -1,6 +1,6 @@
 package dotfiles
 
-func Link(repoInput string, specified []string, dry bool) error {
+func Link(repoInput string, specified []string, opts LinkOptions) error {
 	repo, err := absolutePathToRepo(repoInput)
 	if err != nil {
 		return err
@@ -11,13 +11,24 @@ func Link(repoInput string, specified []string, dry bool) error {
 		return err
 	}
 
+	// Note: Hooks are not run on dry-run since they may have side effects
+	if !opts.Dry {
+		if err := runHook(repo, HookPreLink); err != nil {
+			return err
+		}
+	}
+
 	if len(specified) == 0 {
-		err = m.CreateAllLinks(repo, dry)
+		err = m.CreateAllLinks(repo, opts)
 		if e, ok := err.(*NothingLinkedError); ok {
 			e.RepoPath = repo.String()
 		}
+	} else {
+		err = m.CreateSomeLinks(specified, repo, opts)
+	}
+	if err != nil || opts.Dry {
 		return err
 	}
 
-	return m.CreateSomeLinks(specified, repo, dry)
+	return runHook(repo, HookPostLink)
 }
@@ -48,7 +48,7 @@ func TestLinkAll(t *testing.T) {
 		panic(err)
 	}
 
-	if err := Link("", nil, false); err != nil {
+	if err := Link("", nil, LinkOptions{}); err != nil {
 		t.Error(err)
 	}
 	defer os.Remove("_dist.conf")
@@ -98,14 +98,14 @@ func TestLinkSome(t *testing.T) {
 		panic(err)
 	}
 
-	if err := Link("", []string{"_source.conf"}, false); err != nil {
+	if err := Link("", []string{"_source.conf"}, LinkOptions{}); err != nil {
 		t.Error(err)
 	}
 	defer os.Remove("_dist.conf")
 }
 
 func TestLinkConfigDirDoesNotExist(t *testing.T) {
-	if err := Link("", nil, false); err != nil {
+	if err := Link("", nil, LinkOptions{}); err != nil {
 		if _, ok := err.(*NothingLinkedError); !ok {
 			t.Errorf("Non-existtence of .dotfiles directory does not cause an error: %s", err.Error())
 		}
@@ -113,7 +113,7 @@ func TestLinkConfigDirDoesNotExist(t *testing.T) {
 }
 
 func TestLinkSpecifiedRepoDoesNotExist(t *testing.T) {
-	if err := Link("unknown_directory", nil, false); err == nil {
+	if err := Link("unknown_directory", nil, LinkOptions{}); err == nil {
 		t.Errorf("Should make an error for unknown dotfiles repository")
 	}
 
@@ -137,7 +137,7 @@ func TestLinkSpecifiedRepoDoesNotExist(t *testing.T) {
 		panic(err)
 	}
 
-	if err := Link("_dummy_file", nil, false); err == nil {
+	if err := Link("_dummy_file", nil, LinkOptions{}); err == nil {
 		t.Errorf("Should make an error when repository is actually a file")
 	}
 }
@@ -1,6 +1,6 @@
 package dotfiles
 
-import "fmt"
+import "strings"
 
 func List(specified string) error {
 	repo, err := absolutePathToRepo(specified)
@@ -19,11 +19,23 @@ func List(specified string) error {
 	}
 
 	for _, l := range links {
-		fmt.Printf("'%s' -> '%s'\n", l.src, l.dst)
+		a := Action{Kind: "link", Source: l.Src, Destination: l.Dst, Mapping: l.Mapping, Mode: string(l.Mode), Result: ResultExists}
+		notes := []string{}
+		if l.Mapping != "" {
+			notes = append(notes, l.Mapping)
+		}
+		if l.Mode != "" {
+			notes = append(notes, string(l.Mode))
+		}
+		if len(notes) == 0 {
+			output.action(a, nil, "'%s' -> '%s'\n", l.Src, l.Dst)
+		} else {
+			output.action(a, nil, "'%s' -> '%s' (%s)\n", l.Src, l.Dst, strings.Join(notes, ", "))
+		}
 	}
 
 	if len(links) == 0 {
-		fmt.Printf("No link was found (dotfiles: %s)\n", repo.String())
+		output.message("No link was found (dotfiles: %s)\n", repo.String())
 	}
 
 	return nil
@@ -0,0 +1,56 @@
+package dotfiles
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Plan shows operations to put links without touching the filesystem. When out is not empty, the plan is
+// written to the file in JSON so that it can be reviewed and applied later by Apply.
+func Plan(repoInput string, specified []string, out string, opts LinkOptions) error {
+	repo, err := absolutePathToRepo(repoInput)
+	if err != nil {
+		return err
+	}
+
+	m, err := GetMappings(repo.Join(".dotfiles"))
+	if err != nil {
+		return err
+	}
+	m, err = m.expand(repo)
+	if err != nil {
+		return err
+	}
+
+	l, err := newLinker(repo, opts)
+	if err != nil {
+		return err
+	}
+
+	p, _, err := m.planLinks(m.filesToLink(specified), l)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range p.Operations {
+		op.report(ResultDryRun)
+	}
+	if len(p.Operations) == 0 {
+		output.message("Nothing to do.\n")
+	}
+
+	if out == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(out, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	output.message("\nPlan was written to '%s'. Apply it by `dotfiles apply %s`\n", out, out)
+	return nil
+}
@@ -0,0 +1,30 @@
+package dotfiles
+
+import (
+	"path/filepath"
+
+	"github.com/rhysd/abspath"
+)
+
+func Restore(repoInput string, specified []string, dry bool) error {
+	repo, err := absolutePathToRepo(repoInput)
+	if err != nil {
+		return err
+	}
+
+	m, err := GetMappings(repo.Join(".dotfiles"))
+	if err != nil {
+		return err
+	}
+
+	dsts := make([]abspath.AbsPath, 0, len(specified))
+	for _, s := range specified {
+		p, err := expandDestination(filepath.ToSlash(s))
+		if err != nil {
+			return err
+		}
+		dsts = append(dsts, p)
+	}
+
+	return m.Restore(repo, dsts, dry)
+}
@@ -0,0 +1,104 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreBackedUpFiles(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+
+	cwd := getcwd()
+	dir := filepath.Join(cwd.String(), ".dotfiles")
+	if err := os.MkdirAll(dir, os.ModePerm|os.ModeDir); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dist1 := cwd.Join("_dist1.conf").String()
+	dist2 := cwd.Join("_dist2.conf").String()
+	writeFile(filepath.Join(dir, "mappings.json"), `
+	{
+		"_source1.conf": "`+dist1+`",
+		"_source2.conf": "`+dist2+`"
+	}
+	`)
+
+	for _, f := range []string{"_source1.conf", "_source2.conf"} {
+		openFile(f).Close()
+		defer os.Remove(f)
+	}
+	writeFile("_dist1.conf", "original1")
+	defer os.Remove("_dist1.conf")
+	writeFile("_dist2.conf", "original2")
+	defer os.Remove("_dist2.conf")
+
+	if err := Link("", nil, LinkOptions{Conflict: ConflictBackup}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore("", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if !isSymlinkTo("_dist1.conf", "_source1.conf") || !isSymlinkTo("_dist2.conf", "_source2.conf") {
+		t.Fatalf("Dry run must not remove any link")
+	}
+
+	if err := Restore("", []string{dist1}, false); err != nil {
+		t.Fatal(err)
+	}
+	if readFile("_dist1.conf") != "original1" {
+		t.Fatalf("Backup was not restored")
+	}
+	if !isSymlinkTo("_dist2.conf", "_source2.conf") {
+		t.Fatalf("Not specified destination must not be restored")
+	}
+
+	if err := Restore("", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if readFile("_dist2.conf") != "original2" {
+		t.Fatalf("Backup was not restored")
+	}
+
+	backups, err := filepath.Glob("_dist*.conf" + backupSuffix + "*")
+	if err != nil {
+		panic(err)
+	}
+	if len(backups) != 0 {
+		t.Fatalf("Backups should be moved but still exist: %v", backups)
+	}
+}
+
+func TestRestoreWithAlternateHome(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+
+	repo, home := createTestRepo(map[string]string{"vimrc": "source"}, map[string]string{"vimrc": ".vimrc"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+	writeFile(home.Join(".vimrc").String(), "original")
+
+	if err := SetTarget(home.String(), ""); err != nil {
+		panic(err)
+	}
+	defer SetTarget("", "")
+
+	if err := Link(repo.String(), nil, LinkOptions{Conflict: ConflictBackup}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(repo.String(), []string{"~/.vimrc"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(home.Join(".vimrc").String()) != "original" {
+		t.Fatalf("Destination under alternate home directory should be restored")
+	}
+}
+
+func TestRestoreInvalidRepo(t *testing.T) {
+	if err := Restore("unknown_dir", nil, false); err == nil {
+		t.Errorf("Non-existing repository directory must raise an error")
+	}
+}
@@ -0,0 +1,67 @@
+package dotfiles
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+)
+
+var statusColors = map[LinkStatus]*color.Color{
+	StatusLinked:        color.New(color.FgGreen),
+	StatusMissing:       color.New(color.FgYellow),
+	StatusBlocked:       color.New(color.FgRed),
+	StatusOther:         color.New(color.FgRed),
+	StatusBroken:        color.New(color.FgRed),
+	StatusSourceMissing: color.New(color.FgRed),
+	StatusSkipped:       color.New(color.Faint),
+	StatusOutdated:      color.New(color.FgYellow),
+}
+
+func Status(specified string) error {
+	repo, err := absolutePathToRepo(specified)
+	if err != nil {
+		return err
+	}
+
+	m, err := GetMappings(repo.Join(".dotfiles"))
+	if err != nil {
+		return err
+	}
+
+	sts, err := m.Status(repo)
+	if err != nil {
+		return err
+	}
+
+	counts := map[LinkStatus]int{}
+	for _, s := range sts {
+		counts[s.Status]++
+		a := Action{Kind: "status", Source: s.Source, Destination: s.Destination, Mapping: s.Origin, Result: string(s.Status)}
+		text := "%-14s '%s' -> '%s' (%s)\n"
+		args := []interface{}{s.Status, s.Source, s.Destination, s.Origin}
+		if s.Status == StatusSkipped {
+			a.Reason = s.Reason
+			text = "%-14s '%s' -> '%s' (%s, %s)\n"
+			args = append(args, s.Reason)
+		} else if s.Status == StatusOther || s.Status == StatusBroken {
+			a.Reason = fmt.Sprintf("actually linked to '%s'", s.Target)
+			text = "%-14s '%s' -> '%s' (%s, actually -> '%s')\n"
+			args = append(args, s.Target)
+		}
+		output.action(a, statusColors[s.Status], text, args...)
+	}
+
+	if len(sts) == 0 {
+		output.message("No mapping was found (dotfiles: %s)\n", repo.String())
+		return nil
+	}
+
+	output.message("\n%d linked, %d missing, %d blocked, %d other, %d broken, %d source-missing, %d outdated, %d skipped\n",
+		counts[StatusLinked], counts[StatusMissing], counts[StatusBlocked], counts[StatusOther], counts[StatusBroken], counts[StatusSourceMissing], counts[StatusOutdated], counts[StatusSkipped])
+
+	if n := len(sts) - counts[StatusLinked] - counts[StatusSkipped]; n > 0 {
+		return &OutOfSyncError{n}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatusOutOfSync(t *testing.T) {
+	cwd := getcwd()
+	dir := filepath.Join(cwd.String(), ".dotfiles")
+	if err := os.MkdirAll(dir, os.ModePerm|os.ModeDir); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dist := cwd.Join("_dist.conf").String()
+	writeFile(filepath.Join(dir, "mappings.json"), `{"_source.conf": "`+dist+`"}`)
+	openFile("_source.conf").Close()
+	defer os.Remove("_source.conf")
+
+	err := Status("")
+	if e, ok := err.(*OutOfSyncError); !ok || e.Count != 1 {
+		t.Fatalf("Missing link should be reported as out of sync: %v", err)
+	}
+
+	createSymlink("_source.conf", "_dist.conf")
+	defer os.Remove("_dist.conf")
+
+	if err := Status(""); err != nil {
+		t.Fatalf("All mappings are linked but error occurred: %s", err)
+	}
+}
+
+func TestStatusInvalidRepo(t *testing.T) {
+	if err := Status("unknown_dir"); err == nil {
+		t.Errorf("Non-existing repository directory must raise an error")
+	}
+}
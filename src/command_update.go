@@ -3,14 +3,149 @@ package dotfiles
 import (
 	"os"
 	"os/exec"
+	"path/filepath"
+
+	"github.com/rhysd/abspath"
 )
 
-func Update(repoInput string) error {
+// UpdateOptions is a set of options to update dotfiles repository
+type UpdateOptions struct {
+	// Link links mappings added by pulling and unlinks mappings removed by pulling. When it is nil,
+	// `update_link` in .dotfiles/config.json is used
+	Link *bool
+}
+
+// sourcedMappings returns mappings whose sources exist in the repository
+func sourcedMappings(repo abspath.AbsPath) (Mappings, error) {
+	m, err := GetMappings(repo.Join(".dotfiles"))
+	if err != nil {
+		return nil, err
+	}
+	m, err = m.expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := Mappings{}
+	for k, ds := range m {
+		if _, err := os.Lstat(repo.Join(filepath.FromSlash(k)).String()); err == nil {
+			ret[k] = ds
+		}
+	}
+	return ret, nil
+}
+
+func (maps Mappings) has(src string, dst Destination) bool {
+	for _, d := range maps[src] {
+		if d.Path.String() == dst.Path.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// diff returns mappings which are not included in other
+func (maps Mappings) diff(other Mappings) Mappings {
+	ret := Mappings{}
+	for k, ds := range maps {
+		for _, d := range ds {
+			if !other.has(k, d) {
+				ret[k] = append(ret[k], d)
+			}
+		}
+	}
+	return ret
+}
+
+// relink removes links of mappings which were removed by pulling and puts links of mappings which were
+// added by pulling
+func relink(repo abspath.AbsPath, before, after Mappings) error {
+	removed := before.diff(after)
+	added := after.diff(before)
+
+	if len(removed) == 0 && len(added) == 0 {
+		output.message("No mapping was changed by update.\n")
+		return nil
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return err
+	}
+
+	for key, ds := range removed {
+		// Note: Destination may be shared with another key. Only the link from the removed key is removed
+		src := repo.Join(filepath.FromSlash(key)).String()
+		for _, d := range ds {
+			dst := d.Path.String()
+			e, ok := manifest.find(dst)
+			if !ok {
+				// Note: Link may be put before the manifest was introduced
+				source, err := getLinkSource(repo, d.Path)
+				if err != nil {
+					return err
+				}
+				if source != src {
+					continue
+				}
+				if _, err := removed.unlink(repo, d.Path); err != nil {
+					return err
+				}
+				continue
+			}
+			if e.Source != src {
+				continue
+			}
+			if e.isAlive() {
+				a := Action{Kind: "unlink", Source: e.Source, Destination: dst}
+				if err := e.remove(); err != nil {
+					output.failed(a, err)
+					return err
+				}
+				a.Result = ResultDone
+				output.action(a, nil, "Unlink: '%s' -> '%s'\n", e.Source, dst)
+			}
+			manifest.Remove(dst)
+		}
+	}
+
+	if err := manifest.Save(); err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(added))
+	for f := range added {
+		files = append(files, f)
+	}
+	_, err = added.createLinks(files, repo, LinkOptions{})
+	return err
+}
+
+func Update(repoInput string, opts UpdateOptions) error {
 	repo, err := absolutePathToRepo(repoInput)
 	if err != nil {
 		return err
 	}
 
+	link := false
+	if opts.Link != nil {
+		link = *opts.Link
+	} else {
+		c, err := readRepoConfig(repo)
+		if err != nil {
+			return err
+		}
+		link = c.UpdateLink
+	}
+
+	var before Mappings
+	if link {
+		before, err = sourcedMappings(repo)
+		if err != nil {
+			return err
+		}
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -31,5 +166,15 @@ func Update(repoInput string) error {
 		return err
 	}
 
-	return nil
+	if link {
+		after, err := sourcedMappings(repo)
+		if err != nil {
+			return err
+		}
+		if err := relink(repo, before, after); err != nil {
+			return err
+		}
+	}
+
+	return runHook(repo, HookPostUpdate)
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestUpdateErrorCase(t *testing.T) {
-	if err := Update("unknown_repo"); err == nil {
+	if err := Update("unknown_repo", UpdateOptions{}); err == nil {
 		t.Fatalf("It should raise an error when unknown repository specified")
 	}
 
@@ -16,7 +16,7 @@ func TestUpdateErrorCase(t *testing.T) {
 		panic(err)
 	}
 
-	if err := Update(filepath.Base(cwd)); err == nil {
+	if err := Update(filepath.Base(cwd), UpdateOptions{}); err == nil {
 		t.Fatalf("If it is not a Git repository, it should raise an error")
 	}
 }
@@ -26,10 +26,109 @@ func TestUpdateOk(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	if err := Update(".."); err != nil {
+	if err := Update("..", UpdateOptions{}); err != nil {
 		t.Fatal(err)
 	}
 	if c, _ := os.Getwd(); c != cwd {
 		t.Fatalf("Current working directory is wrong. '%s' should be '%s'", c, cwd)
 	}
 }
+
+func TestRelinkChangedMappings(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	repo, home := createTestRepo(map[string]string{"_a.conf": "", "_b.conf": ""}, map[string]string{"_a.conf": "a.conf"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+	mappings := repo.Join(".dotfiles", "mappings.json").String()
+
+	if err := Link(repo.String(), nil, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := sourcedMappings(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mappings are changed by pulling
+	writeFile(mappings, `{"_b.conf": "`+home.Join("b.conf").ToSlash()+`"}`)
+	after, err := sourcedMappings(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relink(repo, before, after); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(home.Join("a.conf").String()); err == nil {
+		t.Errorf("Link of removed mapping should be removed")
+	}
+	if s, err := readLink(home.Join("b.conf").String()); err != nil || s != repo.Join("_b.conf").String() {
+		t.Errorf("Link of added mapping should be put: %v", err)
+	}
+
+	m, err := LoadManifest(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.find(home.Join("a.conf").String()); ok {
+		t.Errorf("Removed link should be removed from manifest: %+v", m.Links)
+	}
+}
+
+func TestRelinkKeepsLinkFromAnotherKey(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	repo, home := createTestRepo(map[string]string{"_a.conf": "", "_b.conf": ""}, map[string]string{"_b.conf": "x.conf"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	if err := Link(repo.String(), nil, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := home.Join("x.conf")
+	before := Mappings{"_a.conf": {Destination{Path: dst}}, "_b.conf": {Destination{Path: dst}}}
+	after := Mappings{"_b.conf": {Destination{Path: dst}}}
+
+	// Note: Second relink checks the link put before the manifest was introduced
+	for i := 0; i < 2; i++ {
+		if err := relink(repo, before, after); err != nil {
+			t.Fatal(err)
+		}
+		if s, err := readLink(dst.String()); err != nil || s != repo.Join("_b.conf").String() {
+			t.Fatalf("Link from another key should not be removed (%d): %v", i, err)
+		}
+		resetManifest()
+	}
+}
+
+func TestReadRepoConfig(t *testing.T) {
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+
+	c, err := readRepoConfig(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UpdateLink {
+		t.Errorf("update_link should be false by default")
+	}
+
+	if err := os.Mkdir(repo.Join(".dotfiles").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(repo.Join(".dotfiles", "config.yaml").String(), "update_link: true\n")
+	c, err = readRepoConfig(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.UpdateLink {
+		t.Errorf("update_link should be read from config file")
+	}
+
+	writeFile(repo.Join(".dotfiles", "config.yaml").String(), "unknown: true\n")
+	if _, err := readRepoConfig(repo); err == nil {
+		t.Errorf("Unknown key in config file should cause an error")
+	}
+}
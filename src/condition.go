@@ -0,0 +1,92 @@
+package dotfiles
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+
+	"github.com/rhysd/abspath"
+)
+
+// Condition is a condition to link a destination. It is specified with "when" in mappings files. All
+// non-empty fields must be satisfied.
+type Condition struct {
+	// OS is a platform name like "linux", "darwin", "windows" or "unixlike"
+	OS string `json:"os,omitempty"`
+	// Hostname is a glob pattern of the host name like "build-*"
+	Hostname string `json:"hostname,omitempty"`
+	// Env is a map from an environment variable name to a glob pattern of its value
+	Env map[string]string `json:"env,omitempty"`
+	// Exists is a path which must exist
+	Exists string `json:"exists,omitempty"`
+}
+
+func (c *Condition) validate() error {
+	if c == nil {
+		return nil
+	}
+	if _, err := path.Match(c.Hostname, ""); err != nil {
+		return fmt.Errorf("invalid glob pattern for hostname in \"when\": %q", c.Hostname)
+	}
+	for name, pat := range c.Env {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern for $%s in \"when\": %q", name, pat)
+		}
+	}
+	return nil
+}
+
+func (c *Condition) matchOS(platform string) bool {
+	if c.OS == unixLikePlatformName {
+		return isUnixLikePlatform(platform)
+	}
+	return c.OS == platform
+}
+
+func (c *Condition) matchHostname() bool {
+	for _, h := range hostnames() {
+		if ok, _ := path.Match(c.Hostname, h); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns true when the condition is satisfied. Otherwise it returns false with a reason. nil
+// condition is always satisfied.
+func (c *Condition) Check() (bool, string) {
+	if c == nil {
+		return true, ""
+	}
+
+	if c.OS != "" && !c.matchOS(runtime.GOOS) {
+		return false, fmt.Sprintf("OS is not %s", c.OS)
+	}
+
+	if c.Hostname != "" && !c.matchHostname() {
+		return false, fmt.Sprintf("hostname does not match to '%s'", c.Hostname)
+	}
+
+	for name, pat := range c.Env {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return false, fmt.Sprintf("$%s is not set", name)
+		}
+		if m, _ := path.Match(pat, v); !m {
+			return false, fmt.Sprintf("$%s does not match to '%s'", name, pat)
+		}
+	}
+
+	if c.Exists != "" {
+		p, err := abspath.ExpandFromSlash(c.Exists)
+		if err != nil {
+			return false, err.Error()
+		}
+		if _, err := os.Stat(p.String()); err != nil {
+			return false, fmt.Sprintf("'%s' does not exist", c.Exists)
+		}
+	}
+
+	return true, ""
+}
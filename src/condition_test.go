@@ -0,0 +1,66 @@
+package dotfiles
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestConditionNil(t *testing.T) {
+	var c *Condition
+	if ok, _ := c.Check(); !ok {
+		t.Fatalf("nil condition must be always satisfied")
+	}
+}
+
+func TestConditionSatisfied(t *testing.T) {
+	os.Setenv("DOTFILES_TEST_COND", "true")
+	defer os.Unsetenv("DOTFILES_TEST_COND")
+
+	c := &Condition{
+		OS:       runtime.GOOS,
+		Hostname: "*",
+		Env:      map[string]string{"DOTFILES_TEST_COND": "t*"},
+		Exists:   getcwd().Join("condition_test.go").ToSlash(),
+	}
+	if ok, reason := c.Check(); !ok {
+		t.Fatalf("Condition should be satisfied but not: %s", reason)
+	}
+}
+
+func TestConditionNotSatisfied(t *testing.T) {
+	os.Setenv("DOTFILES_TEST_COND", "false")
+	defer os.Unsetenv("DOTFILES_TEST_COND")
+
+	for _, c := range []*Condition{
+		{OS: "unknown"},
+		{Hostname: "this-host-never-exists-*"},
+		{Env: map[string]string{"DOTFILES_TEST_COND": "true"}},
+		{Env: map[string]string{"DOTFILES_TEST_UNKNOWN_VAR": "*"}},
+		{Exists: "/path/to/unknown/file"},
+	} {
+		ok, reason := c.Check()
+		if ok {
+			t.Errorf("Condition %+v should not be satisfied", c)
+		}
+		if reason == "" {
+			t.Errorf("Reason should be reported for %+v", c)
+		}
+	}
+}
+
+func TestConditionUnixLike(t *testing.T) {
+	c := &Condition{OS: "unixlike"}
+	if !c.matchOS("linux") || !c.matchOS("darwin") || c.matchOS("windows") {
+		t.Fatalf("'unixlike' should match to Linux and macOS")
+	}
+}
+
+func TestConditionInvalidPattern(t *testing.T) {
+	if err := (&Condition{Hostname: "["}).validate(); err == nil {
+		t.Errorf("Broken glob pattern for hostname should cause an error")
+	}
+	if err := (&Condition{Env: map[string]string{"FOO": "["}}).validate(); err == nil {
+		t.Errorf("Broken glob pattern for env should cause an error")
+	}
+}
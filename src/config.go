@@ -0,0 +1,62 @@
+package dotfiles
+
+import (
+	"os"
+
+	"github.com/rhysd/abspath"
+)
+
+// repoConfig is a configuration of dotfiles repository put in .dotfiles/config.{json,yaml,yml,toml}
+type repoConfig struct {
+	// UpdateLink is whether `update` links mappings changed by pulling by default
+	UpdateLink bool `json:"update_link"`
+	// Defaults is whether the built-in default mappings are used. They are used when it is omitted
+	Defaults *bool `json:"defaults"`
+}
+
+func readRepoConfig(repo abspath.AbsPath) (*repoConfig, error) {
+	c := &repoConfig{}
+	file, ok := findMappingsFile(repo.Join(".dotfiles"), "config")
+	if !ok {
+		return c, nil
+	}
+	if err := decodeConfigFile(file, c, "config"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// userConfigDir returns the directory of user-wide configurations. It is $XDG_CONFIG_HOME/dotfiles or
+// ~/.config/dotfiles
+func userConfigDir() (abspath.AbsPath, error) {
+	if env := os.Getenv("XDG_CONFIG_HOME"); env != "" {
+		p, err := abspath.ExpandFrom(env)
+		if err != nil {
+			return abspath.AbsPath{}, err
+		}
+		return p.Join("dotfiles"), nil
+	}
+	return abspath.ExpandFromSlash("~/.config/dotfiles")
+}
+
+// userConfig is a user-wide configuration put in $XDG_CONFIG_HOME/dotfiles/config.{json,yaml,yml,toml}
+type userConfig struct {
+	// GitHost is a host name used for `clone` when only user name or repository name is specified
+	GitHost string `json:"git_host"`
+}
+
+func readUserConfig() (*userConfig, error) {
+	c := &userConfig{}
+	dir, err := userConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	file, ok := findMappingsFile(dir, "config")
+	if !ok {
+		return c, nil
+	}
+	if err := decodeConfigFile(file, c, "config"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
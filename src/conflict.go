@@ -0,0 +1,70 @@
+package dotfiles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ConflictStrategy is a way to handle a file which already exists at the destination of a link
+type ConflictStrategy string
+
+const (
+	// ConflictSkip leaves the existing file as-is and does not link
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictBackup moves the existing file to a backup path before linking
+	ConflictBackup ConflictStrategy = "backup"
+	// ConflictOverwrite removes the existing file before linking
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	// ConflictAdopt moves the existing file into the dotfiles repository as the source of the link
+	ConflictAdopt ConflictStrategy = "adopt"
+	// ConflictAsk asks user which strategy should be used for each existing file
+	ConflictAsk ConflictStrategy = "ask"
+)
+
+const backupSuffix = ".dotfiles-backup-"
+
+// backupPathFor returns a path to back up the destination. Since the timestamp is in seconds, a counter is
+// appended when the path already exists not to overwrite the previous backup.
+func backupPathFor(dst string) string {
+	base := dst + backupSuffix + time.Now().Format("20060102150405")
+	p := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p
+		}
+		p = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func askConflictStrategy(r *bufio.Reader, dst string) (ConflictStrategy, error) {
+	for {
+		// Note: Output to stderr not to break JSON output
+		fmt.Fprintf(os.Stderr, "'%s' already exists. [s]kip, [b]ackup, [o]verwrite or [a]dopt? ", dst)
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "s", "skip":
+			return ConflictSkip, nil
+		case "b", "backup":
+			return ConflictBackup, nil
+		case "o", "overwrite":
+			return ConflictOverwrite, nil
+		case "a", "adopt":
+			return ConflictAdopt, nil
+		case "":
+			// Note: Skipping is the safest choice when no answer is given
+			return ConflictSkip, nil
+		}
+
+		if err == io.EOF {
+			return ConflictSkip, nil
+		}
+	}
+}
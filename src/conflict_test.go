@@ -0,0 +1,212 @@
+package dotfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(n string) string {
+	b, err := ioutil.ReadFile(n)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func writeFile(n, content string) {
+	if err := ioutil.WriteFile(n, []byte(content), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func TestConflictSkip(t *testing.T) {
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+	writeFile("_test.conf", "existing")
+	defer os.Remove("_test.conf")
+
+	err := m.CreateAllLinks(cwd, LinkOptions{Conflict: ConflictSkip})
+	if _, ok := err.(*NothingLinkedError); !ok {
+		t.Fatalf("Existing file should not be reported as linked: %v", err)
+	}
+	if readFile("_test.conf") != "existing" {
+		t.Fatalf("Existing file must not be modified")
+	}
+}
+
+func TestConflictBackup(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+	writeFile("_test.conf", "existing")
+	defer os.Remove("_test.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Conflict: ConflictBackup}); err != nil {
+		t.Fatal(err)
+	}
+	if !isSymlinkTo("_test.conf", "._test_source.conf") {
+		t.Fatalf("Symbolic link not found")
+	}
+
+	backups, err := filepath.Glob("_test.conf" + backupSuffix + "*")
+	if err != nil {
+		panic(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Exactly one backup should be created but actually %v", backups)
+	}
+	defer os.Remove(backups[0])
+	if readFile(backups[0]) != "existing" {
+		t.Fatalf("Backup content is broken")
+	}
+
+	manifest, err := LoadManifest(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Links) != 1 || manifest.Links[0].Backup != cwd.Join(backups[0]).String() {
+		t.Fatalf("Backup should be recorded in manifest: %v", manifest.Links)
+	}
+}
+
+func TestConflictOverwrite(t *testing.T) {
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+	writeFile("_test.conf", "existing")
+	defer os.Remove("_test.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Conflict: ConflictOverwrite}); err != nil {
+		t.Fatal(err)
+	}
+	if !isSymlinkTo("_test.conf", "._test_source.conf") {
+		t.Fatalf("Symbolic link not found")
+	}
+}
+
+func TestConflictAdopt(t *testing.T) {
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	defer os.Remove("._test_source.conf")
+	writeFile("_test.conf", "existing")
+	defer os.Remove("_test.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Conflict: ConflictAdopt}); err != nil {
+		t.Fatal(err)
+	}
+	if !isSymlinkTo("_test.conf", "._test_source.conf") {
+		t.Fatalf("Symbolic link not found")
+	}
+	if readFile("._test_source.conf") != "existing" {
+		t.Fatalf("Existing file should be moved into repository")
+	}
+}
+
+func TestConflictAdoptWithoutSource(t *testing.T) {
+	repo, home := createTestRepo(map[string]string{"mine": ""}, nil)
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+	writeFile(home.Join(".bashrc").String(), "default")
+	writeFile(home.Join(".zshrc").String(), "explicit")
+
+	dst := home.Join(".zshrc")
+	m := Mappings{
+		"mine":    {Destination{Path: home.Join(".mine"), Origin: "mappings.json"}},
+		".bashrc": {Destination{Path: home.Join(".bashrc"), Origin: defaultMappingsOrigin}},
+		"bashrc":  {Destination{Path: home.Join(".bashrc"), Origin: defaultMappingsOrigin}},
+		".zshrc":  {Destination{Path: dst, Origin: "mappings.json"}},
+		"zshrc":   {Destination{Path: dst, Origin: "mappings.json"}},
+	}
+	if err := m.CreateAllLinks(repo, LinkOptions{Conflict: ConflictAdopt}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := os.Lstat(home.Join(".bashrc").String()); err != nil || s.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("File matching only default mappings should be left as-is: %v", err)
+	}
+
+	// Note: Only the first key of the keys mapped to the same destination adopts the file
+	if s, err := os.Lstat(repo.Join(".zshrc").String()); err != nil || !s.Mode().IsRegular() {
+		t.Fatalf("Explicitly mapped file should be adopted as a regular file: %v", err)
+	}
+	if _, err := os.Lstat(repo.Join("zshrc").String()); err == nil {
+		t.Fatalf("Adopted file should not be adopted again by another key")
+	}
+	if readFile(dst.String()) != "explicit" {
+		t.Fatalf("Adopted file should be linked back")
+	}
+}
+
+func TestBackupPathIsUnique(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir.String())
+	dst := dir.Join(".vimrc").String()
+
+	first := backupPathFor(dst)
+	writeFile(first, "")
+	second := backupPathFor(dst)
+	if first == second {
+		t.Fatalf("Backup path should not conflict with existing backup: %s", second)
+	}
+	if !strings.HasPrefix(second, dst+backupSuffix) {
+		t.Fatalf("Backup path is unexpected: %s", second)
+	}
+}
+
+func TestConflictDryRun(t *testing.T) {
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+	writeFile("_test.conf", "existing")
+	defer os.Remove("_test.conf")
+
+	for _, c := range []ConflictStrategy{ConflictBackup, ConflictOverwrite, ConflictAdopt} {
+		if err := m.CreateAllLinks(cwd, LinkOptions{Dry: true, Conflict: c}); err != nil {
+			t.Fatal(err)
+		}
+		if readFile("_test.conf") != "existing" {
+			t.Fatalf("Existing file must not be modified on dry run with %s", c)
+		}
+		if readFile("._test_source.conf") != "this file is for test" {
+			t.Fatalf("Source file must not be modified on dry run with %s", c)
+		}
+	}
+}
+
+func TestConflictAsk(t *testing.T) {
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+	writeFile("_test.conf", "existing")
+	defer os.Remove("_test.conf")
+
+	stdinSaved := os.Stdin
+	r, w, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdinSaved
+	}()
+	w.WriteString("what?\no\n")
+	w.Close()
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Conflict: ConflictAsk}); err != nil {
+		t.Fatal(err)
+	}
+	if !isSymlinkTo("_test.conf", "._test_source.conf") {
+		t.Fatalf("Existing file should be overwritten by answering 'o'")
+	}
+}
@@ -0,0 +1,60 @@
+package dotfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// xdgDefaults is default values of XDG base directory variables defined in the specification. They are
+// used when the variables are not set.
+var xdgDefaults = map[string]string{
+	"XDG_CONFIG_HOME": "~/.config",
+	"XDG_DATA_HOME":   "~/.local/share",
+	"XDG_STATE_HOME":  "~/.local/state",
+	"XDG_CACHE_HOME":  "~/.cache",
+}
+
+func lookupEnv(name string) (string, bool) {
+	if name == "HOME" && target.home != "" {
+		return target.home, true
+	}
+	v := os.Getenv(name)
+	if d, ok := xdgDefaults[name]; ok && (!filepath.IsAbs(v) || target.home != "") {
+		// Note: The specification says relative paths in XDG variables are invalid and should be ignored.
+		// Variables of the current user are not used for alternate home directory.
+		return d, true
+	}
+	return v, v != ""
+}
+
+// expandEnv expands $VAR and ${VAR} in the string. ${VAR:-default} is expanded to the default when VAR is
+// not set or empty. Referring a variable which is not set without default causes an error. '$$' is
+// expanded to '$'.
+func expandEnv(s string) (string, error) {
+	var err error
+	ret := os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		def, hasDef := "", false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDef = name[:i], name[i+2:], true
+		}
+		if v, ok := lookupEnv(name); ok {
+			return v
+		}
+		if hasDef {
+			return def
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable $%s is referred in '%s' but not set", name, s)
+		}
+		return ""
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
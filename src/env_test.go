@@ -0,0 +1,72 @@
+package dotfiles
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("DOTFILES_TEST_DIR", "/path/to/work")
+	defer os.Unsetenv("DOTFILES_TEST_DIR")
+	os.Unsetenv("DOTFILES_TEST_UNSET")
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	for input, want := range map[string]string{
+		"~/.vimrc":                                   "~/.vimrc",
+		"$DOTFILES_TEST_DIR/.envrc":                  "/path/to/work/.envrc",
+		"${DOTFILES_TEST_DIR}/.envrc":                "/path/to/work/.envrc",
+		"${DOTFILES_TEST_UNSET:-/tmp}/.envrc":        "/tmp/.envrc",
+		"${DOTFILES_TEST_DIR:-/tmp}/.envrc":          "/path/to/work/.envrc",
+		"$XDG_CONFIG_HOME/nvim/init.lua":             "~/.config/nvim/init.lua",
+		"${XDG_DATA_HOME}/fonts/":                    "~/.local/share/fonts/",
+		"/path/to/$$dollar":                          "/path/to/$dollar",
+		"${DOTFILES_TEST_UNSET:-~/.config}/foo.conf": "~/.config/foo.conf",
+	} {
+		have, err := expandEnv(input)
+		if err != nil {
+			t.Errorf("Expanding '%s' caused an error: %s", input, err)
+			continue
+		}
+		if have != want {
+			t.Errorf("'%s' should be expanded to '%s' but got '%s'", input, want, have)
+		}
+	}
+
+	os.Setenv("XDG_CONFIG_HOME", "/path/to/config")
+	if have, err := expandEnv("$XDG_CONFIG_HOME/nvim"); err != nil || have != "/path/to/config/nvim" {
+		t.Errorf("$XDG_CONFIG_HOME should be used when it is set: %s (%v)", have, err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", "relative/config")
+	if have, err := expandEnv("$XDG_CONFIG_HOME/nvim"); err != nil || have != "~/.config/nvim" {
+		t.Errorf("Relative $XDG_CONFIG_HOME should be ignored: %s (%v)", have, err)
+	}
+
+	if _, err := expandEnv("$DOTFILES_TEST_UNSET/.envrc"); err == nil {
+		t.Errorf("Unset variable without default should cause an error")
+	}
+}
+
+func TestGetMappingsExpandEnv(t *testing.T) {
+	os.Setenv("DOTFILES_TEST_DIR", "/path/to/work")
+	defer os.Unsetenv("DOTFILES_TEST_DIR")
+
+	testDir := createTestJSON("mappings.json", `{"envrc": "${DOTFILES_TEST_DIR}/.envrc"}`)
+	defer os.RemoveAll(testDir)
+	p := getcwd().Join(testDir)
+
+	m, err := GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasOnlyDestination(m, "envrc", "/path/to/work/.envrc") {
+		t.Errorf("Environment variable in destination should be expanded: %v", m["envrc"])
+	}
+
+	os.Unsetenv("DOTFILES_TEST_DIR")
+	if _, err := GetMappingsForPlatform("unknown", p); err == nil {
+		t.Errorf("Unset variable in destination should cause an error")
+	}
+}
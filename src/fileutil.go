@@ -0,0 +1,94 @@
+package dotfiles
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func copyFile(from, to string, mode os.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// copyTree copies a file, a directory or a symbolic link recursively
+func copyTree(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(to, rel)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			s, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(s, dst)
+		case info.IsDir():
+			return os.MkdirAll(dst, info.Mode().Perm())
+		default:
+			return copyFile(path, dst, info.Mode().Perm())
+		}
+	})
+}
+
+// readLink returns the target of the symbolic link. A relative target is resolved from the directory of
+// the link. When the root directory is specified, the target seen from the root is converted into the
+// actual path.
+func readLink(p string) (string, error) {
+	t, err := os.Readlink(p)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(t) {
+		t = filepath.Join(inRoot(filepath.Dir(p)), t)
+	}
+	return fromRoot(t), nil
+}
+
+// moveFile moves a file or a directory. When renaming is not possible since they are on different devices,
+// it falls back into copying and removing the original.
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	_, existed := os.Lstat(to)
+	if err := copyTree(from, to); err != nil {
+		// Note: Do not remove the file which existed before moving
+		if os.IsNotExist(existed) {
+			os.RemoveAll(to)
+		}
+		return err
+	}
+
+	return os.RemoveAll(from)
+}
@@ -0,0 +1,68 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyTree(t *testing.T) {
+	if err := os.MkdirAll(filepath.Join("_test_tree", "nested"), os.ModeDir|os.ModePerm); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll("_test_tree")
+	writeFile(filepath.Join("_test_tree", "nested", "file"), "hello")
+	if err := os.Symlink("nested/file", filepath.Join("_test_tree", "link")); err != nil {
+		panic(err)
+	}
+
+	if err := copyTree("_test_tree", "_test_tree_copied"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("_test_tree_copied")
+
+	if readFile(filepath.Join("_test_tree_copied", "nested", "file")) != "hello" {
+		t.Errorf("File in nested directory was not copied")
+	}
+	if s, err := os.Readlink(filepath.Join("_test_tree_copied", "link")); err != nil || s != "nested/file" {
+		t.Errorf("Symbolic link should be copied as-is: %v %v", s, err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	writeFile("_test_move.conf", "hello")
+	defer os.Remove("_test_move.conf")
+
+	if err := moveFile("_test_move.conf", "_test_moved.conf"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("_test_moved.conf")
+
+	if _, err := os.Lstat("_test_move.conf"); err == nil {
+		t.Errorf("Original file must be removed")
+	}
+	if readFile("_test_moved.conf") != "hello" {
+		t.Errorf("File was not moved")
+	}
+}
+
+func TestMoveFileOntoExistingDirectory(t *testing.T) {
+	for _, d := range []string{"_test_move_from", "_test_move_to"} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(d)
+		writeFile(filepath.Join(d, "file"), d)
+	}
+
+	// Note: Renaming onto a non-empty directory fails without falling back into copying
+	if err := moveFile("_test_move_from", "_test_move_to"); err == nil {
+		t.Fatalf("Moving onto existing non-empty directory should fail")
+	}
+	if readFile(filepath.Join("_test_move_to", "file")) != "_test_move_to" {
+		t.Errorf("Existing destination must not be changed")
+	}
+	if readFile(filepath.Join("_test_move_from", "file")) != "_test_move_from" {
+		t.Errorf("Source must not be removed")
+	}
+}
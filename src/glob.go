@@ -0,0 +1,160 @@
+package dotfiles
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rhysd/abspath"
+)
+
+func isGlobPattern(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+// globBase returns the directory part of the pattern which does not contain any glob character.
+// e.g. "config/**" -> "config", "bin/*.sh" -> "bin", "*" -> ""
+func globBase(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	for i, s := range segs {
+		if isGlobPattern(s) {
+			return strings.Join(segs[:i], "/")
+		}
+	}
+	return path.Dir(pattern)
+}
+
+// matchSegments matches slash-separated path segments against pattern segments. "**" matches zero or
+// more segments.
+func matchSegments(pats, segs []string) bool {
+	if len(pats) == 0 {
+		return len(segs) == 0
+	}
+	if pats[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pats[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pats[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegments(pats[1:], segs[1:])
+}
+
+// expandGlob returns slash-separated paths relative to repo which match to the pattern. Patterns
+// containing "**" match files recursively. Other patterns match both files and directories like shell.
+func expandGlob(repo abspath.AbsPath, pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		ms, err := filepath.Glob(repo.Join(filepath.FromSlash(pattern)).String())
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, 0, len(ms))
+		for _, m := range ms {
+			rel, err := filepath.Rel(repo.String(), m)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, filepath.ToSlash(rel))
+		}
+		return ret, nil
+	}
+
+	pats := strings.Split(pattern, "/")
+	root := repo.Join(filepath.FromSlash(globBase(pattern)))
+	ret := []string{}
+	err := filepath.Walk(root.String(), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(repo.String(), p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchSegments(pats, strings.Split(rel, "/")) {
+			ret = append(ret, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// resolveDir returns the actual destination of the file. When the destination is a directory, the file is
+// linked into the directory as rel. The mode of the destination is also resolved.
+func resolveDir(d Destination, file, rel string) Destination {
+	d.Mode = defaultModeFor(file, d)
+	if !d.Dir {
+		return d
+	}
+	d.Path = d.Path.Join(filepath.FromSlash(d.Mode.trimExt(rel)))
+	d.Dir = false
+	return d
+}
+
+// expand expands glob keys into keys of matched files and resolves destinations which are directories and
+// modes of destinations.
+// Mappings for explicit keys take precedence over ones expanded from glob keys.
+func (maps Mappings) expand(repo abspath.AbsPath) (Mappings, error) {
+	ret := make(Mappings, len(maps))
+	globs := []string{}
+	for k, ds := range maps {
+		if isGlobPattern(k) {
+			globs = append(globs, k)
+			continue
+		}
+		resolved := make([]Destination, 0, len(ds))
+		for _, d := range ds {
+			resolved = append(resolved, resolveDir(d, k, path.Base(k)))
+		}
+		ret[k] = resolved
+	}
+
+	// Note: Sort to make the result stable when multiple patterns match to the same file
+	sort.Strings(globs)
+	for _, g := range globs {
+		files, err := expandGlob(repo, g)
+		if err != nil {
+			return nil, err
+		}
+		base := globBase(g)
+		for _, f := range files {
+			if _, ok := ret[f]; ok {
+				continue
+			}
+			rel := f
+			if base != "" && base != "." {
+				rel = strings.TrimPrefix(f, base+"/")
+			}
+			ds := make([]Destination, 0, len(maps[g]))
+			for _, d := range maps[g] {
+				ds = append(ds, resolveDir(d, f, rel))
+			}
+			ret[f] = ds
+		}
+	}
+
+	return ret, nil
+}
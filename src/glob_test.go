@@ -0,0 +1,158 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGlobBase(t *testing.T) {
+	for pat, want := range map[string]string{
+		"config/**":     "config",
+		"bin/*":         "bin",
+		"bin/*.sh":      "bin",
+		"*":             "",
+		"a/b/**/c/*.rc": "a/b",
+	} {
+		if have := globBase(pat); have != want {
+			t.Errorf("Base of '%s' should be '%s' but got '%s'", pat, want, have)
+		}
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	for _, tc := range []struct {
+		pat  string
+		path string
+		want bool
+	}{
+		{"config/**", "config/nvim/init.vim", true},
+		{"config/**", "config/foo", true},
+		{"config/**", "other/foo", false},
+		{"config/**/*.vim", "config/init.vim", true},
+		{"config/**/*.vim", "config/nvim/init.vim", true},
+		{"config/**/*.vim", "config/nvim/init.lua", false},
+		{"bin/*", "bin/foo", true},
+		{"bin/*", "bin/foo/bar", false},
+	} {
+		have := matchSegments(strings.Split(tc.pat, "/"), strings.Split(tc.path, "/"))
+		if have != tc.want {
+			t.Errorf("Matching '%s' against '%s' should be %v", tc.pat, tc.path, tc.want)
+		}
+	}
+}
+
+func createGlobTestRepo() string {
+	dir := "_test_glob_repo"
+	for _, f := range []string{"bin/foo", "bin/bar", "config/nvim/init.vim", "config/git/config", "vimrc"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			panic(err)
+		}
+		openFile(p).Close()
+	}
+	return dir
+}
+
+func TestExpandGlob(t *testing.T) {
+	dir := createGlobTestRepo()
+	defer os.RemoveAll(dir)
+	repo := getcwd().Join(dir)
+
+	have, err := expandGlob(repo, "bin/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bin/bar", "bin/foo"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("Wanted %v but got %v", want, have)
+	}
+
+	have, err = expandGlob(repo, "config/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"config/git/config", "config/nvim/init.vim"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("Wanted %v but got %v", want, have)
+	}
+
+	have, err = expandGlob(repo, "nothing/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != 0 {
+		t.Errorf("Nothing should match but got %v", have)
+	}
+}
+
+func TestExpandMappings(t *testing.T) {
+	dir := createGlobTestRepo()
+	defer os.RemoveAll(dir)
+	repo := getcwd().Join(dir)
+	home := getcwd().Join("_home")
+
+	m := Mappings{
+		"bin/*":     []Destination{{Path: home.Join(".local", "bin"), Origin: "mappings.json", Dir: true}},
+		"config/**": []Destination{{Path: home.Join(".config"), Origin: "mappings.json", Dir: true}},
+		// Explicit key takes precedence over glob keys
+		"config/git/config": []Destination{{Path: home.Join(".gitconfig"), Origin: "mappings.json"}},
+		"vimrc":             []Destination{{Path: home.Join("vim"), Origin: "mappings.json", Dir: true}},
+	}
+
+	expanded, err := m.expand(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"bin/foo":              home.Join(".local", "bin", "foo").String(),
+		"bin/bar":              home.Join(".local", "bin", "bar").String(),
+		"config/nvim/init.vim": home.Join(".config", "nvim", "init.vim").String(),
+		"config/git/config":    home.Join(".gitconfig").String(),
+		"vimrc":                home.Join("vim", "vimrc").String(),
+	}
+	if len(expanded) != len(want) {
+		t.Fatalf("Unexpected expanded mappings: %v", expanded)
+	}
+	for k, dst := range want {
+		ds, ok := expanded[k]
+		if !ok || len(ds) != 1 {
+			t.Errorf("Key '%s' should be expanded to one destination: %v", k, ds)
+			continue
+		}
+		if ds[0].Path.String() != dst || ds[0].Dir {
+			t.Errorf("Destination of '%s' should be '%s' but got %+v", k, dst, ds[0])
+		}
+	}
+}
+
+func TestLinkGlobKey(t *testing.T) {
+	dir := createGlobTestRepo()
+	defer os.RemoveAll(dir)
+	repo := getcwd().Join(dir)
+	home := getcwd().Join("_test_glob_home")
+	defer os.RemoveAll(home.String())
+
+	m := Mappings{
+		"config/**": []Destination{{Path: home.Join(".config"), Origin: "mappings.json", Dir: true}},
+	}
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"nvim/init.vim", "git/config"} {
+		dst := filepath.Join("_test_glob_home", ".config", filepath.FromSlash(f))
+		src := filepath.Join(dir, "config", filepath.FromSlash(f))
+		if !isSymlinkTo(dst, src) {
+			t.Errorf("'%s' should be linked to '%s'", dst, src)
+		}
+	}
+
+	if err := m.UnlinkAll(repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(home.Join(".config", "git", "config").String()); err == nil {
+		t.Errorf("Link should be removed by clean")
+	}
+}
@@ -0,0 +1,79 @@
+package dotfiles
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/rhysd/abspath"
+)
+
+// Names of hook scripts put in .dotfiles/hooks
+const (
+	HookPreLink    = "pre-link"
+	HookPostLink   = "post-link"
+	HookPreClean   = "pre-clean"
+	HookPostClean  = "post-clean"
+	HookPostUpdate = "post-update"
+	HookPostClone  = "post-clone"
+)
+
+var hooksEnabled = true
+
+// SetHooksEnabled sets whether hook scripts in .dotfiles/hooks are run
+func SetHooksEnabled(enabled bool) {
+	hooksEnabled = enabled
+}
+
+// HookError is an error raised when a hook script failed
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (err *HookError) Error() string {
+	if strings.HasPrefix(err.Hook, "pre-") {
+		return fmt.Sprintf("%s hook failed and the command was aborted: %s", err.Hook, err.Err)
+	}
+	return fmt.Sprintf("%s hook failed: %s", err.Hook, err.Err)
+}
+
+// runHook runs the hook script at .dotfiles/hooks/{name} in the repository directory when it exists. The
+// repository path, the platform and the destinations of links changed by the command so far are passed
+// via environment variables. The script must be executable.
+func runHook(repo abspath.AbsPath, name string) error {
+	if !hooksEnabled {
+		return nil
+	}
+
+	script := repo.Join(".dotfiles", "hooks", name)
+	if s, err := os.Stat(script.String()); err != nil || s.IsDir() {
+		return nil
+	}
+
+	output.message("Hook:  %s\n", name)
+
+	cmd := exec.Command(script.String())
+	cmd.Dir = repo.String()
+	cmd.Env = append(os.Environ(),
+		"DOTFILES_HOOK="+name,
+		"DOTFILES_REPO_PATH="+repo.String(),
+		"DOTFILES_PLATFORM="+runtime.GOOS,
+		"DOTFILES_CHANGED_LINKS="+strings.Join(output.changed, "\n"),
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if output.format == FormatJSON {
+		// Note: Standard output is reserved for JSON output
+		cmd.Stdout = os.Stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		return &HookError{name, err}
+	}
+
+	return nil
+}
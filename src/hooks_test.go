@@ -0,0 +1,92 @@
+package dotfiles
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rhysd/abspath"
+)
+
+func writeHook(repo abspath.AbsPath, name, script string) {
+	dir := repo.Join(".dotfiles", "hooks")
+	if err := os.MkdirAll(dir.String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(dir.Join(name).String(), "#!/bin/sh\n"+script+"\n")
+	if err := os.Chmod(dir.Join(name).String(), 0755); err != nil {
+		panic(err)
+	}
+}
+
+func TestLinkAndCleanRunHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts in this test are shell scripts")
+	}
+	resetManifest()
+	defer resetManifest()
+	SetOutputFormat(FormatText)
+	defer SetOutputFormat(FormatText)
+
+	repo, home := createTestRepo(map[string]string{"vimrc": ""}, map[string]string{"vimrc": ".vimrc"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	log := repo.Join("hook.log").String()
+	for _, name := range []string{HookPreLink, HookPostLink, HookPreClean, HookPostClean} {
+		writeHook(repo, name, `echo "$DOTFILES_HOOK $DOTFILES_REPO_PATH $DOTFILES_CHANGED_LINKS" >> `+log)
+	}
+
+	if err := Link(repo.String(), nil, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Note: Changed links are reset for each command
+	SetOutputFormat(FormatText)
+	if err := Clean(repo.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := home.Join(".vimrc").String()
+	want := []string{
+		"pre-link " + repo.String() + " ",
+		"post-link " + repo.String() + " " + dst,
+		"pre-clean " + repo.String() + " ",
+		"post-clean " + repo.String() + " " + dst,
+	}
+	have := strings.Split(strings.TrimSuffix(readFile(log), "\n"), "\n")
+	if strings.Join(have, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Hooks were not run as expected. Wanted %q but got %q", want, have)
+	}
+}
+
+func TestPreHookFailureAbortsCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts in this test are shell scripts")
+	}
+	resetManifest()
+	defer resetManifest()
+
+	repo, home := createTestRepo(map[string]string{"vimrc": ""}, map[string]string{"vimrc": ".vimrc"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	writeHook(repo, HookPreLink, "exit 1")
+
+	err := Link(repo.String(), nil, LinkOptions{})
+	if _, ok := err.(*HookError); !ok {
+		t.Fatalf("Failure of pre-link hook should cause HookError: %v", err)
+	}
+	if _, err := os.Lstat(home.Join(".vimrc").String()); err == nil {
+		t.Fatalf("Link should not be put when pre-link hook failed")
+	}
+
+	SetHooksEnabled(false)
+	defer SetHooksEnabled(true)
+	if err := Link(repo.String(), nil, LinkOptions{}); err != nil {
+		t.Fatalf("Hooks should be skipped when disabled: %s", err)
+	}
+	if _, err := os.Lstat(home.Join(".vimrc").String()); err != nil {
+		t.Fatalf("Link should be put when hooks are disabled: %s", err)
+	}
+}
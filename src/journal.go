@@ -0,0 +1,137 @@
+package dotfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// journalEntry is a change on filesystem made by applying an operation. It is used for undoing the change
+// when linking fails halfway.
+type journalEntry struct {
+	op Operation
+	// dirs is directories created by the operation from the outermost one
+	dirs []string
+	// aside is a path where the removed file was moved. It is actually removed when linking is committed
+	aside string
+	// link is the raw target of the folded link which was replaced with a real directory
+	link string
+}
+
+// missingDirs returns the directory and its ancestors which do not exist from the outermost one
+func missingDirs(dir string) []string {
+	dirs := []string{}
+	for d := dir; d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+		dirs = append([]string{d}, dirs...)
+	}
+	return dirs
+}
+
+// moveAside moves the file to a temporary path in the same directory instead of removing it so that it
+// can be restored on rollback. It returns an empty string when the file does not exist.
+func moveAside(p string) (string, error) {
+	if _, err := os.Lstat(p); err != nil {
+		return "", nil
+	}
+	aside := filepath.Join(filepath.Dir(p), fmt.Sprintf(".%s.dotfiles-removing-%d", filepath.Base(p), os.Getpid()))
+	if err := os.Rename(p, aside); err != nil {
+		return "", err
+	}
+	return aside, nil
+}
+
+func (e *journalEntry) undo() error {
+	switch e.op.Kind {
+	case OpUnfold:
+		if err := os.Remove(e.op.Destination); err != nil {
+			return err
+		}
+		if err := os.Symlink(e.link, e.op.Destination); err != nil {
+			return err
+		}
+	case OpBackup:
+		if err := moveFile(e.op.Destination, e.op.Source); err != nil {
+			return err
+		}
+	case OpRemove:
+		if err := os.Rename(e.aside, e.op.Destination); err != nil {
+			return err
+		}
+	case OpAdopt:
+		if err := moveFile(e.op.Destination, e.op.Source); err != nil {
+			return err
+		}
+		if e.aside != "" {
+			if err := os.Rename(e.aside, e.op.Destination); err != nil {
+				return err
+			}
+		}
+	case OpLink:
+		if err := os.RemoveAll(e.op.Destination); err != nil {
+			return err
+		}
+	}
+
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(e.dirs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollback undoes the changes journaled so far in reverse order and returns an error which describes the
+// cause of the rollback. Manifest is not saved so that it remains as before linking.
+func (l *linker) rollback(cause error) error {
+	failed := []string{}
+	for i := len(l.journal) - 1; i >= 0; i-- {
+		e := &l.journal[i]
+		a := Action{Kind: "rollback", Source: e.op.Source, Destination: e.op.Destination}
+		if err := e.undo(); err != nil {
+			output.failed(a, err)
+			failed = append(failed, fmt.Sprintf("%s '%s': %s", e.op.Kind, e.op.Destination, err))
+			continue
+		}
+		a.Result = ResultDone
+		output.action(a, color.New(color.FgYellow), "Rollback: %s '%s'\n", e.op.Kind, e.op.Destination)
+	}
+	l.journal = nil
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s. Rolling back some changes failed:\n  %s", cause, strings.Join(failed, "\n  "))
+	}
+	return fmt.Errorf("%s. All changes made by linking were rolled back", cause)
+}
+
+// commit removes the files moved aside by the operations applied so far
+func (l *linker) commit() error {
+	for _, e := range l.journal {
+		if e.aside != "" {
+			if err := os.RemoveAll(e.aside); err != nil {
+				return err
+			}
+		}
+	}
+	l.journal = nil
+	return nil
+}
+
+// LinkFailedError is an error raised when linking continued on failures with --keep-going option
+type LinkFailedError struct {
+	Errs []error
+}
+
+func (err *LinkFailedError) Error() string {
+	ss := make([]string, 0, len(err.Errs))
+	for _, e := range err.Errs {
+		ss = append(ss, e.Error())
+	}
+	return fmt.Sprintf("%d operation(s) failed on linking:\n  %s", len(err.Errs), strings.Join(ss, "\n  "))
+}
@@ -0,0 +1,297 @@
+package dotfiles
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rhysd/abspath"
+)
+
+// setupFailingRepo sets up mappings where the last link fails since the parent of its destination is a file
+func setupFailingRepo() (abspath.AbsPath, abspath.AbsPath, Mappings) {
+	repo, home := createTestRepo(
+		map[string]string{"_a.conf": "_a.conf", "_b.conf": "_b.conf", "_c.conf": "_c.conf"},
+		map[string]string{"_a.conf": "a/.a.conf", "_b.conf": ".b.conf", "_c.conf": "file/.c.conf"},
+	)
+	writeFile(home.Join(".b.conf").String(), "existing")
+	writeFile(home.Join("file").String(), "")
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		panic(err)
+	}
+	return repo, home, m
+}
+
+func filesIn(dir abspath.AbsPath) []string {
+	fs, err := ioutil.ReadDir(dir.String())
+	if err != nil {
+		panic(err)
+	}
+	names := []string{}
+	for _, f := range fs {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestLinkFailureRollsBack(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating a file inside a file is not rejected in the same way on Windows")
+	}
+	repo, home, m := setupFailingRepo()
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	err := m.CreateAllLinks(repo, LinkOptions{Conflict: ConflictOverwrite})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Linking should fail and be rolled back: %v", err)
+	}
+
+	if names := filesIn(home); strings.Join(names, " ") != ".b.conf file" {
+		t.Fatalf("Home directory should be restored as before linking: %v", names)
+	}
+	if readFile(home.Join(".b.conf").String()) != "existing" {
+		t.Fatalf("Overwritten file should be restored")
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Links) != 0 {
+		t.Fatalf("Rolled back links should not be recorded: %+v", manifest.Links)
+	}
+}
+
+func TestLinkKeepGoingOnFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating a file inside a file is not rejected in the same way on Windows")
+	}
+	repo, home, m := setupFailingRepo()
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	err := m.CreateAllLinks(repo, LinkOptions{Conflict: ConflictOverwrite, KeepGoing: true})
+	e, ok := err.(*LinkFailedError)
+	if !ok || len(e.Errs) != 1 || !strings.Contains(e.Errs[0].Error(), "file/.c.conf") {
+		t.Fatalf("Failure should be reported at the end: %v", err)
+	}
+
+	for _, n := range []string{"a/.a.conf", ".b.conf"} {
+		if s, err := os.Lstat(home.Join(n).String()); err != nil || s.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("Link at '%s' should be put on failure of other link: %v", n, err)
+		}
+	}
+	if names := filesIn(home); strings.Join(names, " ") != ".b.conf a file" {
+		t.Fatalf("Overwritten file should be removed: %v", names)
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Links) != 2 {
+		t.Fatalf("Successful links should be recorded: %+v", manifest.Links)
+	}
+}
+
+func TestRollbackRestoresBackupAndUnfoldedLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links are not available on Windows in test")
+	}
+	repo := tempDir()
+	home := tempDir()
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	if err := os.Mkdir(repo.Join("dir").String(), 0755); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink(repo.Join("dir").String(), home.Join("folded").String()); err != nil {
+		panic(err)
+	}
+	writeFile(home.Join("conf").String(), "existing")
+
+	l, err := newLinker(repo, LinkOptions{})
+	if err != nil {
+		panic(err)
+	}
+	for _, op := range []Operation{
+		{Kind: OpUnfold, Source: repo.Join("dir").String(), Destination: home.Join("folded").String()},
+		{Kind: OpBackup, Source: home.Join("conf").String(), Destination: home.Join("conf.bak").String()},
+	} {
+		if err := l.do(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.rollback(os.ErrNotExist); err == nil {
+		t.Fatalf("Rollback should return an error which describes its cause")
+	}
+
+	if raw, err := os.Readlink(home.Join("folded").String()); err != nil || raw != repo.Join("dir").String() {
+		t.Fatalf("Folded link should be restored: %v", err)
+	}
+	if readFile(home.Join("conf").String()) != "existing" {
+		t.Fatalf("Backed up file should be moved back")
+	}
+	if _, err := os.Lstat(home.Join("conf.bak").String()); err == nil {
+		t.Fatalf("Backup should not remain after rollback")
+	}
+}
+
+func TestFailedOperationLeavesNothing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix domain socket and path length limit are different on Windows")
+	}
+	repo := tempDir()
+	home := tempDir()
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	// Note: Copying the directory fails at the socket after the first file was copied
+	src := repo.Join("dir")
+	if err := os.Mkdir(src.String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(src.Join("a.conf").String(), "")
+	sock, err := net.Listen("unix", src.Join("z.sock").String())
+	if err != nil {
+		panic(err)
+	}
+	defer sock.Close()
+
+	l, err := newLinker(repo, LinkOptions{})
+	if err != nil {
+		panic(err)
+	}
+	for _, op := range []Operation{
+		// Note: Making the first directory succeeds but the second one fails since its name is too long
+		{Kind: OpMkdir, Destination: home.Join("a", strings.Repeat("x", 256)).String()},
+		{Kind: OpLink, Mode: ModeCopy, Source: src.String(), Destination: home.Join("dir").String()},
+	} {
+		if err := l.do(op); err == nil {
+			t.Fatalf("Operation %s should fail", op.Kind)
+		}
+		if names := filesIn(home); len(names) != 0 {
+			t.Fatalf("Nothing should remain on failure of %s: %v", op.Kind, names)
+		}
+	}
+	if len(l.journal) != 0 {
+		t.Fatalf("Failed operations should not be journaled: %+v", l.journal)
+	}
+}
+
+func TestApplyStalePlanRollsBack(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating a directory inside a file is not rejected in the same way on Windows")
+	}
+	repo, home := createTestRepo(
+		map[string]string{"_a.conf": "", "_b.conf": ""},
+		map[string]string{"_a.conf": ".a.conf", "_b.conf": "b/.b.conf"},
+	)
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+	writeFile(home.Join(".a.conf").String(), "existing")
+
+	out := repo.Join("plan.json").String()
+	if err := Plan(repo.String(), nil, out, LinkOptions{Conflict: ConflictOverwrite}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Filesystem is changed after planning
+	if err := os.Remove(home.Join(".a.conf").String()); err != nil {
+		panic(err)
+	}
+	writeFile(home.Join("b").String(), "")
+
+	err := Apply(out, false)
+	if err == nil || !strings.Contains(err.Error(), "All changes made by linking were rolled back") {
+		t.Fatalf("Applying stale plan should fail and be rolled back cleanly: %v", err)
+	}
+	if names := filesIn(home); strings.Join(names, " ") != "b" {
+		t.Fatalf("Home directory should be restored as before applying: %v", names)
+	}
+}
+
+func TestInterruptRollsBack(t *testing.T) {
+	repo, home := createTestRepo(map[string]string{"_a.conf": ""}, map[string]string{"_a.conf": "a/.a.conf"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		panic(err)
+	}
+	l, err := newLinker(repo, LinkOptions{})
+	if err != nil {
+		panic(err)
+	}
+	p, _, err := m.planLinks(m.filesToLink(nil), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Note: Interruption is noticed after the first operation
+	interrupted := make(chan os.Signal, 1)
+	interrupted <- os.Interrupt
+	err = l.transact(p, interrupted)
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("Interrupted linking should fail: %v", err)
+	}
+
+	if names := filesIn(home); len(names) != 0 {
+		t.Fatalf("Directory made before interruption should be removed: %v", names)
+	}
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Links) != 0 {
+		t.Fatalf("Interrupted links should not be recorded: %+v", manifest.Links)
+	}
+}
+
+func TestManifestSaveFailureRollsBack(t *testing.T) {
+	repo, home := createTestRepo(map[string]string{"_a.conf": ""}, map[string]string{"_a.conf": ".a.conf"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+	writeFile(home.Join(".a.conf").String(), "existing")
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		panic(err)
+	}
+	l, err := newLinker(repo, LinkOptions{Conflict: ConflictOverwrite})
+	if err != nil {
+		panic(err)
+	}
+	p, _, err := m.planLinks(m.filesToLink(nil), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Note: A directory at the manifest path cannot be written as a file
+	f, err := manifestFile(repo)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(f.String(), 0755); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(f.String())
+
+	if err := l.transact(p, nil); err == nil {
+		t.Fatalf("Failure on saving manifest should cause an error")
+	}
+	if readFile(home.Join(".a.conf").String()) != "existing" {
+		t.Fatalf("Overwritten file should be restored when manifest cannot be saved")
+	}
+	if names := filesIn(home); len(names) != 1 {
+		t.Fatalf("No file should remain aside: %v", names)
+	}
+}
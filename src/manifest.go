@@ -0,0 +1,178 @@
+package dotfiles
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rhysd/abspath"
+)
+
+// ManifestEntry is a record of one symbolic link, copy or hard link put by this command
+type ManifestEntry struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Mapping     string    `json:"mapping"`
+	LinkedAt    time.Time `json:"linked_at"`
+	// Backup is a path where the file existing at the destination was moved before linking
+	Backup string `json:"backup,omitempty"`
+	// Mode is a way how the source was put. Empty means a symbolic link
+	Mode LinkMode `json:"mode,omitempty"`
+	// Checksum is a checksum of the copied or hard-linked file to know whether it was modified after put
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Manifest records all symbolic links put from one dotfiles repository. It is stored in
+// $XDG_STATE_HOME/dotfiles so that links whose mappings were removed later can still be found.
+type Manifest struct {
+	Repository string          `json:"repository"`
+	Links      []ManifestEntry `json:"links"`
+	file       string
+}
+
+func stateDir() (abspath.AbsPath, error) {
+	if env := os.Getenv("XDG_STATE_HOME"); env != "" {
+		p, err := abspath.ExpandFrom(env)
+		if err != nil {
+			return abspath.AbsPath{}, err
+		}
+		return p.Join("dotfiles"), nil
+	}
+	return abspath.ExpandFromSlash("~/.local/state/dotfiles")
+}
+
+func manifestFile(repo abspath.AbsPath) (abspath.AbsPath, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return abspath.AbsPath{}, err
+	}
+	// Note: Escape path separators in the same way as Vim's 'undodir'
+	r := strings.NewReplacer(string(filepath.Separator), "%", ":", "%")
+	name := r.Replace(repo.String())
+	// Note: Links put into alternate home or root directory are recorded separately from actual ones
+	if target.home != "" {
+		name += "@home" + r.Replace(target.home)
+	}
+	if target.root != "" {
+		name += "@root" + r.Replace(target.root)
+	}
+	return dir.Join(name + ".json"), nil
+}
+
+func LoadManifest(repo abspath.AbsPath) (*Manifest, error) {
+	file, err := manifestFile(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Repository: repo.String(), file: file.String()}
+
+	bytes, err := ioutil.ReadFile(m.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Note:
+			// It's not an error that the file is not found. Nothing was linked yet.
+			return m, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bytes, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) Save() error {
+	if len(m.Links) == 0 {
+		if err := os.Remove(m.file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.file), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(m.file, bytes, 0644)
+}
+
+func (m *Manifest) Add(e ManifestEntry) {
+	if e.LinkedAt.IsZero() {
+		e.LinkedAt = time.Now()
+	}
+	for i, l := range m.Links {
+		if l.Destination == e.Destination {
+			if e.Backup == "" {
+				// Note: Keep the backup taken when the link was put at first
+				e.Backup = l.Backup
+			}
+			m.Links[i] = e
+			return
+		}
+	}
+	m.Links = append(m.Links, e)
+}
+
+func (m *Manifest) find(dst string) (ManifestEntry, bool) {
+	for _, l := range m.Links {
+		if l.Destination == dst {
+			return l, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+func (m *Manifest) Remove(dst string) {
+	for i, l := range m.Links {
+		if l.Destination == dst {
+			m.Links = append(m.Links[:i], m.Links[i+1:]...)
+			return
+		}
+	}
+}
+
+// isAlive returns true when the recorded symlink still exists and points to the recorded source, or the
+// copied or hard-linked file is not modified after it was put. It prevents removing a file which was
+// replaced by user after it was linked.
+func (e *ManifestEntry) isAlive() bool {
+	if e.Mode.isSymlink() {
+		s, err := os.Lstat(e.Destination)
+		if err != nil || s.Mode()&os.ModeSymlink != os.ModeSymlink {
+			return false
+		}
+		source, err := readLink(e.Destination)
+		if err != nil {
+			return false
+		}
+		return source == e.Source
+	}
+
+	if e.Mode == ModeHardlink && sameFile(e.Source, e.Destination) {
+		return true
+	}
+
+	if e.Checksum == "" {
+		return false
+	}
+	c, err := checksumOf(e.Destination)
+	return err == nil && c == e.Checksum
+}
+
+// remove removes the file put at the destination
+func (e *ManifestEntry) remove() error {
+	if e.Mode.isSymlink() {
+		return os.Remove(e.Destination)
+	}
+	return os.RemoveAll(e.Destination)
+}
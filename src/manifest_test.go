@@ -0,0 +1,181 @@
+package dotfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Note: Do not touch the manifests in actual $XDG_STATE_HOME while running tests
+	dir, err := ioutil.TempDir("", "dotfiles-test-state")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_STATE_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func resetManifest() {
+	m, err := LoadManifest(getcwd())
+	if err != nil {
+		panic(err)
+	}
+	m.Links = nil
+	if err := m.Save(); err != nil {
+		panic(err)
+	}
+}
+
+func TestManifestNotExist(t *testing.T) {
+	m, err := LoadManifest(getcwd().Join("_unknown_repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Links) != 0 {
+		t.Fatalf("Manifest must be empty when nothing was linked: %v", m.Links)
+	}
+}
+
+func TestManifestUnreadable(t *testing.T) {
+	repo := getcwd().Join("_unreadable_repo")
+	f, err := manifestFile(repo)
+	if err != nil {
+		panic(err)
+	}
+	// Note: A directory at the manifest path cannot be read as a file
+	if err := os.MkdirAll(f.String(), 0755); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(f.String())
+
+	if _, err := LoadManifest(repo); err == nil {
+		t.Fatalf("Unreadable manifest should cause an error")
+	}
+}
+
+func TestManifestRecordCreatedLinks(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("_test.conf")
+
+	manifest, err := LoadManifest(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Links) != 1 {
+		t.Fatalf("One link should be recorded but actually %v", manifest.Links)
+	}
+	e := manifest.Links[0]
+	if e.Source != cwd.Join("._test_source.conf").String() {
+		t.Errorf("Unexpected source was recorded: '%s'", e.Source)
+	}
+	if e.Destination != cwd.Join("_test.conf").String() {
+		t.Errorf("Unexpected destination was recorded: '%s'", e.Destination)
+	}
+	if e.Mapping != "mappings.json" {
+		t.Errorf("Unexpected mapping was recorded: '%s'", e.Mapping)
+	}
+	if e.LinkedAt.IsZero() {
+		t.Errorf("Timestamp was not recorded")
+	}
+}
+
+func TestManifestDryRunRecordsNothing(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Dry: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := LoadManifest(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Links) != 0 {
+		t.Fatalf("Nothing should be recorded on dry run but actually %v", manifest.Links)
+	}
+}
+
+func TestManifestOrphanedLink(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("_test.conf")
+
+	// Mapping was removed after linking
+	removed := Mappings{}
+
+	links, err := removed.ActualLinks(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].Dst != cwd.Join("_test.conf").String() {
+		t.Fatalf("Link recorded in manifest should be listed but actually %v", links)
+	}
+
+	if err := removed.UnlinkAll(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat("_test.conf"); err == nil {
+		t.Fatalf("Orphaned link must be removed")
+	}
+
+	manifest, err := LoadManifest(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Links) != 0 {
+		t.Fatalf("Removed link must be removed from manifest: %v", manifest.Links)
+	}
+}
+
+func TestManifestDoNotRemoveReplacedFile(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// User replaced the symlink with their own file
+	if err := os.Remove("_test.conf"); err != nil {
+		panic(err)
+	}
+	openFile("_test.conf").Close()
+	defer os.Remove("_test.conf")
+
+	if err := (Mappings{}).UnlinkAll(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat("_test.conf"); err != nil {
+		t.Fatalf("File not put by this command must not be removed: %s", err)
+	}
+}
@@ -1,10 +1,10 @@
 package dotfiles
 
 import (
-	"encoding/json"
+	"bufio"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"os/user"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -27,9 +27,42 @@ func (err NothingLinkedError) Error() string {
 // unixLikePlatformName is a special platform name used commonly for Unix-like platform (Linux and macOS)
 const unixLikePlatformName = "unixlike"
 
-type Mappings map[string][]abspath.AbsPath
+// Destination is a path where a file in dotfiles repository is linked
+type Destination struct {
+	Path abspath.AbsPath
+	// Origin is a name of mappings which defines the destination. "default" or file name like "mappings.json"
+	Origin string
+	// When is a condition to link the destination. nil means always
+	When *Condition
+	// Dir is true when the destination path ends with '/'. Then the source is linked into the directory.
+	Dir bool
+	// Package is a name of package when the destination is mapped by package mode
+	Package string
+	// Mode is how the source is put at the destination. Empty means a symbolic link
+	Mode LinkMode
+	// Relative is true when a relative symbolic link should be put
+	Relative bool
+}
+
+func (d Destination) String() string {
+	return d.Path.String()
+}
+
+type Mappings map[string][]Destination
 type mappingsJSON map[string][]string
 
+// mappingValue is one value of mappings in mappings files. It is written as a destination path string or
+// an object like {"dst": "~/.foo", "when": {"os": "linux"}}
+type mappingValue struct {
+	Dst      string     `json:"dst"`
+	When     *Condition `json:"when,omitempty"`
+	Mode     LinkMode   `json:"mode,omitempty"`
+	Relative bool       `json:"relative,omitempty"`
+}
+type mappingValues map[string][]mappingValue
+
+const defaultMappingsOrigin = "default"
+
 var defaultMappings = map[string]mappingsJSON{
 	"windows": mappingsJSON{
 		".gvimrc": []string{"~/vimfiles/gvimrc"},
@@ -96,74 +129,86 @@ var defaultMappings = map[string]mappingsJSON{
 }
 
 type PathLink struct {
-	src, dst string
+	Src string `json:"source"`
+	Dst string `json:"destination"`
+	// Mapping is a name of mappings which defines the link. It is empty when unknown.
+	Mapping string `json:"mapping,omitempty"`
+	// Mode is how the source was put. It is empty for symbolic links
+	Mode LinkMode `json:"mode,omitempty"`
 }
 
-func parseMappingsJSON(file abspath.AbsPath) (mappingsJSON, error) {
-	var m map[string]interface{}
-
-	bytes, err := ioutil.ReadFile(file.String())
-	if err != nil {
-		// Note:
-		// It's not an error that the file is not found
+func convertMappingsJSONToMappings(json mappingsJSON, origin string) (Mappings, error) {
+	if json == nil {
 		return nil, nil
 	}
-
-	if err := json.Unmarshal(bytes, &m); err != nil {
-		return nil, err
-	}
-
-	maps := make(mappingsJSON, len(m))
-	for k, v := range m {
-		switch v := v.(type) {
-		case string:
-			maps[k] = []string{v}
-		case []interface{}:
-			vs := make([]string, 0, len(v))
-			for _, iface := range v {
-				s, ok := iface.(string)
-				if !ok {
-					return nil, fmt.Errorf("value of mappings object must be string or string[]: %v", v)
-				}
-				vs = append(vs, s)
-			}
-			maps[k] = vs
+	vals := make(mappingValues, len(json))
+	for k, vs := range json {
+		ms := make([]mappingValue, 0, len(vs))
+		for _, v := range vs {
+			ms = append(ms, mappingValue{Dst: v})
 		}
+		vals[k] = ms
 	}
-
-	return maps, nil
+	return convertMappingValuesToMappings(vals, origin)
 }
 
-func convertMappingsJSONToMappings(json mappingsJSON) (Mappings, error) {
-	if json == nil {
+func convertMappingValuesToMappings(vals mappingValues, origin string) (Mappings, error) {
+	if vals == nil {
 		return nil, nil
 	}
-	m := make(Mappings, len(json))
-	for k, vs := range json {
+	m := make(Mappings, len(vals))
+	for k, vs := range vals {
 		if k == "" {
-			return nil, fmt.Errorf("empty key cannot be included.  Note: Corresponding value is '%s'", vs)
+			return nil, fmt.Errorf("empty key cannot be included.  Note: Corresponding value is '%v'", vs)
 		}
-		ps := make([]abspath.AbsPath, 0, len(vs))
+		ds := make([]Destination, 0, len(vs))
 		for _, v := range vs {
-			if v == "" {
+			if v.Dst == "" {
 				continue
 			}
-			if v[0] != '~' && v[0] != '/' {
-				return nil, fmt.Errorf("value of mappings must be an absolute path like '/foo/.bar' or '~/.foo': %s", v)
+			dst, err := expandEnv(v.Dst)
+			if err != nil {
+				return nil, err
+			}
+			if dst == "" || (dst[0] != '~' && dst[0] != '/' && !filepath.IsAbs(dst)) {
+				return nil, fmt.Errorf("value of mappings must be an absolute path like '/foo/.bar', '~/.foo' or '$XDG_CONFIG_HOME/foo': %s", v.Dst)
 			}
-			p, err := abspath.ExpandFromSlash(v)
+			p, err := expandDestination(dst)
 			if err != nil {
 				return nil, err
 			}
-			ps = append(ps, p)
+			dir := strings.HasSuffix(dst, "/")
+			if isGlobPattern(k) && !dir {
+				return nil, fmt.Errorf("destination of glob pattern '%s' must be a directory ending with '/': %s", k, v.Dst)
+			}
+			ds = append(ds, Destination{Path: p, Origin: origin, When: v.When, Dir: dir, Mode: v.Mode, Relative: v.Relative})
 		}
-		m[k] = ps
+		m[k] = ds
 	}
 	return m, nil
 }
 
+var defaultMappingsEnabled = true
+
+// SetDefaultMappingsEnabled sets whether the built-in default mappings are used. They can also be disabled
+// with `"defaults": false` in .dotfiles/config.json
+func SetDefaultMappingsEnabled(enabled bool) {
+	defaultMappingsEnabled = enabled
+}
+
+func useDefaultMappings(parent abspath.AbsPath) (bool, error) {
+	if !defaultMappingsEnabled {
+		return false, nil
+	}
+	c, err := readRepoConfig(parent.Dir())
+	if err != nil {
+		return false, err
+	}
+	return c.Defaults == nil || *c.Defaults, nil
+}
+
 func mergeMappingsFromDefault(dist Mappings, platform string) error {
-	m, err := convertMappingsJSONToMappings(defaultMappings[platform])
+	m, err := convertMappingsJSONToMappings(defaultMappings[platform], defaultMappingsOrigin)
 	if err != nil {
 		return err
 	}
@@ -175,8 +220,15 @@ func mergeMappingsFromDefault(dist Mappings, platform string) error {
 	return nil
 }
 
-func mergeMappingsFromFile(dist Mappings, file abspath.AbsPath) error {
-	j, err := parseMappingsJSON(file)
+// mergeMappingsFromFile merges mappings in a mappings file named name. Its extension is one of .json,
+// .yaml, .yml or .toml.
+func mergeMappingsFromFile(dist Mappings, dir abspath.AbsPath, name string) error {
+	file, ok := findMappingsFile(dir, name)
+	if !ok {
+		return nil
+	}
+
+	j, err := parseMappingsFile(file)
 	if err != nil {
 		return err
 	}
@@ -184,9 +236,9 @@ func mergeMappingsFromFile(dist Mappings, file abspath.AbsPath) error {
 		return nil
 	}
 
-	m, err := convertMappingsJSONToMappings(j)
+	m, err := convertMappingValuesToMappings(j, file.Base().String())
 	if err != nil {
-		return err
+		return &MappingsFileError{File: file.String(), Err: err}
 	}
 
 	for k, v := range m {
@@ -200,31 +252,86 @@ func isUnixLikePlatform(platform string) bool {
 	return platform == "linux" || platform == "darwin"
 }
 
-func GetMappingsForPlatform(platform string, parent abspath.AbsPath) (Mappings, error) {
-	m := Mappings{}
+func hostnames() []string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return nil
+	}
+	// Note: Both a short name like 'foo' and a full name like 'foo.local' are available
+	if i := strings.IndexRune(h, '.'); i > 0 {
+		return []string{h[:i], h}
+	}
+	return []string{h}
+}
 
+func username() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	// Note: User name is 'DOMAIN\user' on Windows
+	n := u.Username
+	if i := strings.LastIndexByte(n, '\\'); i >= 0 {
+		n = n[i+1:]
+	}
+	return n
+}
+
+// layeredFileNames returns names of config files like mappings files without extension for the platform.
+// Configurations in later files override earlier ones.
+func layeredFileNames(base, platform string) []string {
+	names := []string{base}
 	if isUnixLikePlatform(platform) {
-		if err := mergeMappingsFromDefault(m, unixLikePlatformName); err != nil {
-			return nil, err
-		}
+		names = append(names, base+"_"+unixLikePlatformName)
 	}
-	if err := mergeMappingsFromDefault(m, platform); err != nil {
-		return nil, err
+	names = append(names, base+"_"+platform)
+	for _, h := range hostnames() {
+		names = append(names, base+"_host_"+h)
+	}
+	if u := username(); u != "" {
+		names = append(names, base+"_user_"+u)
+	}
+	if p := os.Getenv("DOTFILES_PROFILE"); p != "" {
+		names = append(names, base+"_profile_"+p)
 	}
+	return names
+}
 
-	if err := mergeMappingsFromFile(m, parent.Join("mappings.json")); err != nil {
+func GetMappingsForPlatform(platform string, parent abspath.AbsPath) (Mappings, error) {
+	m := Mappings{}
+
+	defaults, err := useDefaultMappings(parent)
+	if err != nil {
 		return nil, err
 	}
+	if defaults {
+		if isUnixLikePlatform(platform) {
+			if err := mergeMappingsFromDefault(m, unixLikePlatformName); err != nil {
+				return nil, err
+			}
+		}
+		if err := mergeMappingsFromDefault(m, platform); err != nil {
+			return nil, err
+		}
+	}
 
-	if isUnixLikePlatform(platform) {
-		if err := mergeMappingsFromFile(m, parent.Join(fmt.Sprintf("mappings_%s.json", unixLikePlatformName))); err != nil {
+	for _, name := range layeredFileNames("mappings", platform) {
+		if err := mergeMappingsFromFile(m, parent, name); err != nil {
 			return nil, err
 		}
 	}
-	if err := mergeMappingsFromFile(m, parent.Join(fmt.Sprintf("mappings_%s.json", platform))); err != nil {
+
+	if err := mergeMappingsFromPackages(m, parent); err != nil {
 		return nil, err
 	}
 
+	// Keys mapped to null or [] only suppress mappings of the same keys
+	for k, ds := range m {
+		if len(ds) == 0 {
+			delete(m, k)
+		}
+	}
+
 	return m, nil
 }
 
@@ -232,47 +339,118 @@ func GetMappings(configDir abspath.AbsPath) (Mappings, error) {
 	return GetMappingsForPlatform(runtime.GOOS, configDir)
 }
 
-func link(from, to abspath.AbsPath, dry bool) (bool, error) {
-	if _, err := os.Stat(from.String()); err != nil {
-		return false, nil
+// LinkOptions is a set of options to put symbolic links
+type LinkOptions struct {
+	Dry      bool
+	Conflict ConflictStrategy
+	// Relative puts relative symbolic links computed from directories of destinations
+	Relative bool
+	// KeepGoing continues linking on failures and reports all of them at the end instead of rolling back
+	// the changes on the first failure
+	KeepGoing bool
+}
+
+type linker struct {
+	repo     abspath.AbsPath
+	opts     LinkOptions
+	manifest *Manifest
+	input    *bufio.Reader
+	gen      *generator
+	// dirs is directories which will exist after the operations planned so far are applied
+	dirs map[string]bool
+	// unfolded is directories planned to be unfolded
+	unfolded []string
+	// planned is destinations planned to be linked so far mapped to their sources
+	planned map[string]string
+	// journal is changes on filesystem made by applying operations. They are undone on rollback
+	journal []journalEntry
+}
+
+func newLinker(repo abspath.AbsPath, opts LinkOptions) (*linker, error) {
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictSkip
 	}
+	return &linker{repo, opts, manifest, nil, newGenerator(repo), map[string]bool{}, nil, map[string]string{}, nil}, nil
+}
 
-	if _, err := os.Stat(to.String()); err == nil {
-		// Target already exists. Skipped.
-		fmt.Printf("Exist: '%s' -> '%s'\n", from, to.String())
-		return true, nil
+func (l *linker) strategyFor(dst string) (ConflictStrategy, error) {
+	if l.opts.Conflict != ConflictAsk {
+		return l.opts.Conflict, nil
+	}
+	if l.input == nil {
+		l.input = bufio.NewReader(os.Stdin)
 	}
+	return askConflictStrategy(l.input, dst)
+}
 
-	if err := os.MkdirAll(to.Dir().String(), os.ModeDir|os.ModePerm); err != nil {
+func (l *linker) isPut(from abspath.AbsPath, to Destination) (bool, error) {
+	if !to.Mode.isGenerated() {
+		return to.Mode.isPut(from.String(), to.Path.String()), nil
+	}
+	b, _, err := l.gen.generate(from.String(), to.Mode)
+	if err != nil {
 		return false, err
 	}
+	return hasContent(b, to.Path.String()), nil
+}
 
-	color.Cyan("Link:  '%s' -> '%s'\n", from, to.String())
+// isOutdated returns true when the destination was copied or hard-linked from the source by this command
+// and was not modified after that though the source was updated. Such file can be replaced safely.
+func (l *linker) isOutdated(from abspath.AbsPath, to Destination) bool {
+	if to.Mode.isSymlink() {
+		return false
+	}
+	e, ok := l.manifest.find(to.Path.String())
+	return ok && e.Source == from.String() && e.Mode == to.Mode && e.isAlive()
+}
 
-	if dry {
-		return true, nil
+func (maps Mappings) createLinks(files []string, dir abspath.AbsPath, opts LinkOptions) (bool, error) {
+	l, err := newLinker(dir, opts)
+	if err != nil {
+		return false, err
 	}
 
-	if err := os.Symlink(from.String(), to.String()); err != nil {
+	p, created, err := maps.planLinks(files, l)
+	if err != nil {
 		return false, err
 	}
 
-	return true, nil
+	return created, l.apply(p)
 }
 
-func (maps Mappings) CreateAllLinks(dir abspath.AbsPath, dry bool) error {
-	created := false
-	for f, tos := range maps {
-		from := dir.Join(filepath.FromSlash(f))
-		for _, to := range tos {
-			linked, err := link(from, to, dry)
-			if err != nil {
-				return err
-			}
-			if linked {
-				created = true
-			}
+// filesToLink returns the specified files which are mapped. When no file is specified, all mapped files
+// are returned.
+func (maps Mappings) filesToLink(specified []string) []string {
+	if len(specified) == 0 {
+		files := make([]string, 0, len(maps))
+		for f := range maps {
+			files = append(files, f)
 		}
+		return files
+	}
+
+	files := make([]string, 0, len(specified))
+	for _, f := range specified {
+		if _, ok := maps[f]; ok {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (maps Mappings) CreateAllLinks(dir abspath.AbsPath, opts LinkOptions) error {
+	maps, err := maps.expand(dir)
+	if err != nil {
+		return err
+	}
+
+	created, err := maps.createLinks(maps.filesToLink(nil), dir, opts)
+	if err != nil {
+		return err
 	}
 
 	if !created {
@@ -282,21 +460,15 @@ func (maps Mappings) CreateAllLinks(dir abspath.AbsPath, dry bool) error {
 	return nil
 }
 
-func (maps Mappings) CreateSomeLinks(specified []string, dir abspath.AbsPath, dry bool) error {
-	created := false
-	for _, f := range specified {
-		if tos, ok := maps[f]; ok {
-			from := dir.Join(filepath.FromSlash(f))
-			for _, to := range tos {
-				linked, err := link(from, to, dry)
-				if err != nil {
-					return err
-				}
-				if linked {
-					created = true
-				}
-			}
-		}
+func (maps Mappings) CreateSomeLinks(specified []string, dir abspath.AbsPath, opts LinkOptions) error {
+	maps, err := maps.expand(dir)
+	if err != nil {
+		return err
+	}
+
+	created, err := maps.createLinks(maps.filesToLink(specified), dir, opts)
+	if err != nil {
+		return err
 	}
 
 	if !created && len(specified) > 0 {
@@ -317,12 +489,13 @@ func getLinkSource(repo, to abspath.AbsPath) (string, error) {
 		return "", nil
 	}
 
-	source, err := os.Readlink(to.String())
+	// Note: Relative link is resolved to know whether it points to a file in the repository
+	source, err := readLink(to.String())
 	if err != nil {
 		return "", err
 	}
 
-	if !strings.HasPrefix(source, repo.String()) {
+	if !strings.HasPrefix(source, repo.String()+string(filepath.Separator)) {
 		// Note: When the symlink is not linked from dotfiles repository.
 		return "", nil
 	}
@@ -336,31 +509,179 @@ func (maps Mappings) unlink(repo, to abspath.AbsPath) (bool, error) {
 		return false, err
 	}
 
+	a := Action{Kind: "unlink", Source: source, Destination: to.String()}
 	if err := os.Remove(to.String()); err != nil {
+		output.failed(a, err)
 		return false, err
 	}
 
-	fmt.Printf("Unlink: '%s' -> '%s'\n", source, to.String())
+	a.Result = ResultDone
+	output.action(a, nil, "Unlink: '%s' -> '%s'\n", source, to.String())
 
 	return true, nil
 }
 
 func (maps Mappings) UnlinkAll(repo abspath.AbsPath) error {
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return err
+	}
+
+	maps, err = maps.expand(repo)
+	if err != nil {
+		return err
+	}
+
 	removed := false
 	for _, tos := range maps {
 		for _, to := range tos {
-			unlinked, err := maps.unlink(repo, to)
+			unlinked, err := maps.unlink(repo, to.Path)
 			if err != nil {
 				return err
 			}
 			if unlinked {
+				manifest.Remove(to.Path.String())
 				removed = true
 			}
 		}
 	}
 
+	// Links recorded in the manifest may no longer be in the current mappings
+	for _, e := range append([]ManifestEntry{}, manifest.Links...) {
+		if e.isAlive() {
+			a := Action{Kind: "unlink", Source: e.Source, Destination: e.Destination}
+			if err := e.remove(); err != nil {
+				output.failed(a, err)
+				return err
+			}
+			a.Result = ResultDone
+			output.action(a, nil, "Unlink: '%s' -> '%s'\n", e.Source, e.Destination)
+			removed = true
+		}
+		manifest.Remove(e.Destination)
+	}
+
+	if err := manifest.Save(); err != nil {
+		return err
+	}
+
 	if !removed {
-		fmt.Printf("No symlink was removed (dotfiles: '%s').\n", repo.String())
+		output.message("No symlink was removed (dotfiles: '%s').\n", repo.String())
+	}
+
+	return nil
+}
+
+// Restore removes symbolic links put by this command and moves the files backed up on linking back
+// into their original places. When dsts is not empty, only the destinations are restored.
+func (maps Mappings) Restore(repo abspath.AbsPath, dsts []abspath.AbsPath, dry bool) error {
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return err
+	}
+
+	maps, err = maps.expand(repo)
+	if err != nil {
+		return err
+	}
+
+	selected := func(dst string) bool {
+		if len(dsts) == 0 {
+			return true
+		}
+		for _, d := range dsts {
+			if d.String() == dst {
+				return true
+			}
+		}
+		return false
+	}
+
+	recorded := make(map[string]struct{}, len(manifest.Links))
+	for _, e := range manifest.Links {
+		recorded[e.Destination] = struct{}{}
+	}
+
+	restored := false
+
+	// Note: Links put before the manifest was introduced have no backup. They are only removed.
+	for _, tos := range maps {
+		for _, to := range tos {
+			dst := to.Path.String()
+			if _, ok := recorded[dst]; ok || !selected(dst) {
+				continue
+			}
+			source, err := getLinkSource(repo, to.Path)
+			if err != nil {
+				return err
+			}
+			if source == "" {
+				continue
+			}
+			a := Action{Kind: "unlink", Source: source, Destination: dst}
+			if !dry {
+				if err := os.Remove(dst); err != nil {
+					output.failed(a, err)
+					return err
+				}
+			}
+			a.Result = dryResult(dry)
+			output.action(a, nil, "Unlink: '%s' -> '%s'\n", source, dst)
+			restored = true
+		}
+	}
+
+	for _, e := range append([]ManifestEntry{}, manifest.Links...) {
+		if !selected(e.Destination) {
+			continue
+		}
+
+		removable := e.isAlive()
+		if removable {
+			a := Action{Kind: "unlink", Source: e.Source, Destination: e.Destination}
+			if !dry {
+				if err := e.remove(); err != nil {
+					output.failed(a, err)
+					return err
+				}
+			}
+			a.Result = dryResult(dry)
+			output.action(a, nil, "Unlink: '%s' -> '%s'\n", e.Source, e.Destination)
+			restored = true
+		}
+
+		if e.Backup != "" {
+			if _, err := os.Lstat(e.Backup); err == nil {
+				a := Action{Kind: "restore", Source: e.Backup, Destination: e.Destination}
+				if _, err := os.Lstat(e.Destination); err == nil && !removable {
+					a.Result = ResultSkipped
+					a.Reason = "destination already exists"
+					output.action(a, nil, "Skip:  Backup '%s' cannot be restored since '%s' already exists\n", e.Backup, e.Destination)
+					continue
+				}
+				if !dry {
+					if err := moveFile(e.Backup, e.Destination); err != nil {
+						output.failed(a, err)
+						return err
+					}
+				}
+				a.Result = dryResult(dry)
+				output.action(a, color.New(color.FgGreen), "Restore: '%s' -> '%s'\n", e.Backup, e.Destination)
+				restored = true
+			}
+		}
+
+		manifest.Remove(e.Destination)
+	}
+
+	if !dry {
+		if err := manifest.Save(); err != nil {
+			return err
+		}
+	}
+
+	if !restored {
+		output.message("Nothing was restored (dotfiles: '%s').\n", repo.String())
 	}
 
 	return nil
@@ -371,21 +692,46 @@ func (maps Mappings) ActualLinks(repo abspath.AbsPath) ([]PathLink, error) {
 	//   my_vimrc -> ~/.vimrc (from user config)
 	//   .vimrc -> ~/.vimrc (from default config)
 	// It might lists up duplicate links. (#9)
-	m := map[PathLink]struct{}{}
-	for _, tos := range maps {
+	maps, err := maps.expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[[2]string]PathLink{}
+	for f, tos := range maps {
 		for _, to := range tos {
-			s, err := getLinkSource(repo, to)
+			s, err := getLinkSource(repo, to.Path)
 			if err != nil {
 				return nil, err
 			}
-			if s != "" {
-				m[PathLink{s, to.String()}] = struct{}{}
+			if s == "" {
+				continue
+			}
+			k := [2]string{s, to.Path.String()}
+			if l, ok := m[k]; ok && l.Mapping != "" {
+				continue
+			}
+			l := PathLink{Src: s, Dst: to.Path.String()}
+			if s == repo.Join(filepath.FromSlash(f)).String() {
+				l.Mapping = to.Origin
 			}
+			m[k] = l
+		}
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range manifest.Links {
+		k := [2]string{e.Source, e.Destination}
+		if _, ok := m[k]; !ok && e.isAlive() {
+			m[k] = PathLink{e.Source, e.Destination, e.Mapping, e.Mode}
 		}
 	}
 
 	ret := make([]PathLink, 0, len(m))
-	for l := range m {
+	for _, l := range m {
 		ret = append(ret, l)
 	}
 
@@ -0,0 +1,397 @@
+package dotfiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rhysd/abspath"
+	"gopkg.in/yaml.v3"
+)
+
+// mappingsFileExts is a list of extensions of mappings files. When files with the same name but
+// different extensions exist, the earlier one in this list takes precedence and the others are ignored.
+var mappingsFileExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// MappingsFileError is an error caused by a mappings file. Line is 0 when the line is unknown.
+type MappingsFileError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (err *MappingsFileError) Error() string {
+	if err.Line <= 0 {
+		return fmt.Sprintf("%s: %s", err.File, err.Err.Error())
+	}
+	return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Err.Error())
+}
+
+// findMappingsFile finds a mappings file named name in dir with any of supported extensions
+func findMappingsFile(dir abspath.AbsPath, name string) (abspath.AbsPath, bool) {
+	found := abspath.AbsPath{}
+	ok := false
+	for _, ext := range mappingsFileExts {
+		p := dir.Join(name + ext)
+		if _, err := os.Stat(p.String()); err != nil {
+			continue
+		}
+		if ok {
+			fmt.Fprintf(os.Stderr, "Warning: '%s' is ignored since '%s' exists\n", p.String(), found.String())
+			continue
+		}
+		found, ok = p, true
+	}
+	return found, ok
+}
+
+func lineAt(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte{'\n'}) + 1
+}
+
+func parseMappingsJSONFile(file string, b []byte) (map[string]interface{}, map[string]int, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		line := 0
+		var serr *json.SyntaxError
+		var terr *json.UnmarshalTypeError
+		if errors.As(err, &serr) {
+			line = lineAt(b, serr.Offset)
+		} else if errors.As(err, &terr) {
+			line = lineAt(b, terr.Offset)
+		}
+		return nil, nil, &MappingsFileError{file, line, err}
+	}
+	return m, jsonValueLines(b), nil
+}
+
+// jsonValueLines returns lines of values of the top-level object in the JSON source
+func jsonValueLines(b []byte) map[string]int {
+	lines := map[string]int{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return lines
+	}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			break
+		}
+		k, ok := t.(string)
+		if !ok {
+			break
+		}
+		// Note: Offset after reading a key points the end of the key. Skip ':' and spaces before the value
+		off := d.InputOffset()
+		for off < int64(len(b)) && bytes.IndexByte([]byte(": \t\r\n"), b[off]) >= 0 {
+			off++
+		}
+		lines[k] = lineAt(b, off)
+		var v json.RawMessage
+		if err := d.Decode(&v); err != nil {
+			break
+		}
+	}
+	return lines
+}
+
+// reErrorLine matches error messages from YAML and TOML parsers like "yaml: line 3: ..." or
+// "toml: line 3 (last key "foo"): ..."
+var reErrorLine = regexp.MustCompile(`line (\d+)(?: \([^)]*\))?: (.+)$`)
+
+func errorWithLine(file string, msg string, err error) error {
+	if m := reErrorLine.FindStringSubmatch(msg); m != nil {
+		l, _ := strconv.Atoi(m[1])
+		return &MappingsFileError{file, l, errors.New(m[2])}
+	}
+	return &MappingsFileError{file, 0, err}
+}
+
+func yamlError(file string, err error) error {
+	msg := err.Error()
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) && len(terr.Errors) > 0 {
+		msg = terr.Errors[0]
+	}
+	return errorWithLine(file, msg, err)
+}
+
+func parseMappingsYAMLFile(file string, b []byte) (map[string]interface{}, map[string]int, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, nil, yamlError(file, err)
+	}
+
+	var m map[string]interface{}
+	if err := n.Decode(&m); err != nil {
+		return nil, nil, yamlError(file, err)
+	}
+
+	// Remember lines of values to report errors
+	lines := map[string]int{}
+	if len(n.Content) > 0 && n.Content[0].Kind == yaml.MappingNode {
+		kvs := n.Content[0].Content
+		for i := 0; i+1 < len(kvs); i += 2 {
+			lines[kvs[i].Value] = kvs[i+1].Line
+		}
+	}
+
+	return m, lines, nil
+}
+
+func parseMappingsTOMLFile(file string, b []byte) (map[string]interface{}, map[string]int, error) {
+	var m map[string]interface{}
+	md, err := toml.Decode(string(b), &m)
+	if err != nil {
+		return nil, nil, errorWithLine(file, err.Error(), err)
+	}
+	return m, tomlKeyLines(b, md), nil
+}
+
+// tomlKeyLines returns lines of top-level keys in the TOML source. Since the TOML parser does not expose
+// positions of keys, each key is searched in the source following the previous one.
+func tomlKeyLines(b []byte, md toml.MetaData) map[string]int {
+	src := strings.Split(string(b), "\n")
+	lines := map[string]int{}
+	i := 0
+	for _, k := range md.Keys() {
+		if len(k) != 1 {
+			continue
+		}
+		name := k[0]
+	search:
+		for ; i < len(src); i++ {
+			l := strings.TrimSpace(src[i])
+			for _, q := range []string{name, strconv.Quote(name), "'" + name + "'"} {
+				if strings.HasPrefix(l, q) && strings.HasPrefix(strings.TrimSpace(l[len(q):]), "=") {
+					lines[name] = i + 1
+					i++
+					break search
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// parseMappingValue parses one value of mappings. A value is a string or an object which has "dst" and
+// optionally "when", "mode" and "relative".
+func parseMappingValue(v interface{}) (mappingValue, error) {
+	switch v := v.(type) {
+	case string:
+		return mappingValue{Dst: v}, nil
+	case map[string]interface{}:
+		// Note: Objects in all formats are decoded as map[string]interface{}. Decode it via JSON to check
+		// its structure strictly.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return mappingValue{}, err
+		}
+		var m mappingValue
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&m); err != nil {
+			return mappingValue{}, fmt.Errorf("invalid object in mappings value %v: %s", v, err)
+		}
+		if m.Dst == "" {
+			return mappingValue{}, fmt.Errorf("\"dst\" must be set in object of mappings value: %v", v)
+		}
+		if err := m.When.validate(); err != nil {
+			return mappingValue{}, err
+		}
+		if err := m.Mode.validate(); err != nil {
+			return mappingValue{}, err
+		}
+		if m.Relative && !m.Mode.isSymlink() {
+			return mappingValue{}, fmt.Errorf("\"relative\" is only available for symbolic links but mode is %q", string(m.Mode))
+		}
+		return m, nil
+	default:
+		return mappingValue{}, fmt.Errorf("value of mappings object must be string, object or array of them: %v", v)
+	}
+}
+
+// readConfigFile reads an object in JSON, YAML or TOML file depending on its extension. It also returns
+// lines of values of the object if available. nil is returned when the file does not exist.
+func readConfigFile(file abspath.AbsPath) (map[string]interface{}, map[string]int, error) {
+	b, err := ioutil.ReadFile(file.String())
+	if err != nil {
+		// Note:
+		// It's not an error that the file is not found
+		return nil, nil, nil
+	}
+
+	switch file.Ext() {
+	case ".yaml", ".yml":
+		return parseMappingsYAMLFile(file.String(), b)
+	case ".toml":
+		return parseMappingsTOMLFile(file.String(), b)
+	default:
+		return parseMappingsJSONFile(file.String(), b)
+	}
+}
+
+// decodeConfigFile decodes an object in the config file into v. Unknown keys cause an error. what is a name
+// of the config used in error messages.
+func decodeConfigFile(file abspath.AbsPath, v interface{}, what string) error {
+	m, _, err := readConfigFile(file)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return &MappingsFileError{file.String(), 0, fmt.Errorf("invalid %s: %s", what, err)}
+	}
+
+	return nil
+}
+
+func parseMappingsFile(file abspath.AbsPath) (mappingValues, error) {
+	m, lines, err := readConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	maps := make(mappingValues, len(m))
+	for k, v := range m {
+		if v == nil {
+			// Note: null suppresses the mapping with the same key in default mappings or earlier files
+			maps[k] = []mappingValue{}
+			continue
+		}
+		vs, ok := v.([]interface{})
+		if !ok {
+			vs = []interface{}{v}
+		}
+		ms := make([]mappingValue, 0, len(vs))
+		for _, iface := range vs {
+			mv, err := parseMappingValue(iface)
+			if err != nil {
+				return nil, &MappingsFileError{file.String(), lines[k], err}
+			}
+			ms = append(ms, mv)
+		}
+		maps[k] = ms
+	}
+
+	return maps, nil
+}
+
+// insertJSONMapping inserts a key-value pair at the end of the JSON object keeping its format
+func insertJSONMapping(b []byte, key, dst string) ([]byte, error) {
+	open := bytes.IndexByte(b, '{')
+	end := bytes.LastIndexByte(b, '}')
+	if open < 0 || end < open {
+		return nil, errors.New("top-level value of JSON mappings file must be an object")
+	}
+
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(dst)
+
+	indent := []byte("  ")
+	if i := bytes.IndexByte(b[open:end], '"'); i >= 0 {
+		line := b[:open+i]
+		if j := bytes.LastIndexByte(line, '\n'); j >= 0 {
+			indent = line[j+1:]
+		}
+	}
+
+	var out bytes.Buffer
+	last := bytes.LastIndexFunc(b[:end], func(r rune) bool { return r != ' ' && r != '\t' && r != '\n' && r != '\r' })
+	out.Write(b[:last+1])
+	if last != open {
+		out.WriteByte(',')
+	}
+	fmt.Fprintf(&out, "\n%s%s: %s\n", indent, k, v)
+	out.Write(b[end:])
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &m); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// insertYAMLMapping inserts a key-value pair at the end of the YAML mapping. Comments are preserved
+func insertYAMLMapping(b []byte, key, dst string) ([]byte, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+
+	if len(n.Content) == 0 {
+		n = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	m := n.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return nil, errors.New("top-level value of YAML mappings file must be a mapping")
+	}
+	m.Content = append(m.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: dst},
+	)
+
+	var out bytes.Buffer
+	e := yaml.NewEncoder(&out)
+	e.SetIndent(2)
+	if err := e.Encode(&n); err != nil {
+		return nil, err
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// insertTOMLMapping appends a key-value pair to the TOML file. Mappings files usually have no table so the
+// pair can be appended at the end
+func insertTOMLMapping(b []byte, key, dst string) ([]byte, error) {
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(dst)
+
+	out := append([]byte{}, b...)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	out = append(out, fmt.Sprintf("%s = %s\n", k, v)...)
+
+	var m map[string]interface{}
+	if _, err := toml.Decode(string(out), &m); err != nil {
+		return nil, err
+	}
+	if _, ok := m[key]; !ok {
+		return nil, errors.New("mapping cannot be appended to TOML mappings file which has tables")
+	}
+	return out, nil
+}
+
+// insertMapping returns the content of the mappings file with a new mapping from key to dst
+func insertMapping(file abspath.AbsPath, b []byte, key, dst string) ([]byte, error) {
+	switch file.Ext() {
+	case ".yaml", ".yml":
+		return insertYAMLMapping(b, key, dst)
+	case ".toml":
+		return insertTOMLMapping(b, key, dst)
+	default:
+		if len(bytes.TrimSpace(b)) == 0 {
+			b = []byte("{\n}\n")
+		}
+		return insertJSONMapping(b, key, dst)
+	}
+}
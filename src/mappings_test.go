@@ -1,6 +1,8 @@
 package dotfiles
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -44,16 +46,60 @@ func createTestJSON(fname, contents string) string {
 	return dir
 }
 
+// tempDir creates a temporary directory and returns its real path
+func tempDir() abspath.AbsPath {
+	dir, err := ioutil.TempDir("", "dotfiles-test-")
+	if err != nil {
+		panic(err)
+	}
+	// Note: Temporary directory may be a symlink (e.g. /var -> /private/var on macOS)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		panic(err)
+	}
+	p, err := abspath.ExpandFrom(dir)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// createTestRepo creates a dotfiles repository and a home directory in temporary directories. The files
+// are written in the repository and .dotfiles/mappings.json maps each source to the path relative to the
+// home directory.
+func createTestRepo(files map[string]string, mappings map[string]string) (abspath.AbsPath, abspath.AbsPath) {
+	repo := tempDir()
+	home := tempDir()
+	for f, content := range files {
+		writeFile(repo.Join(filepath.FromSlash(f)).String(), content)
+	}
+
+	m := make(map[string]string, len(mappings))
+	for src, dst := range mappings {
+		m[src] = home.ToSlash() + "/" + dst
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir(repo.Join(".dotfiles").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(repo.Join(".dotfiles", "mappings.json").String(), string(b))
+
+	return repo, home
+}
+
 func hasOnlyDestination(m Mappings, src string, dest string) bool {
 	if len(m[src]) != 1 {
 		return false
 	}
-	return m[src][0].String() == dest
+	return m[src][0].Path.String() == dest
 }
 
 func mapping(k string, v string) Mappings {
 	m := make(Mappings, 1)
-	m[k] = []abspath.AbsPath{getcwd().Join(v)}
+	m[k] = []Destination{{Path: getcwd().Join(v), Origin: "mappings.json"}}
 	return m
 }
 
@@ -176,7 +222,7 @@ func TestGetMappingsMappingsJson(t *testing.T) {
 	if !hasOnlyDestination(m, ".vimrc", "/override/path/vimrc") {
 		t.Errorf("Mapping should be overridden but actually '%s' for Darwin platform", m[".vimrc"])
 	}
-	if p := m["multi_dest"]; len(p) != 2 || p[0].String() != "/dest1" || p[1].String() != "/dest2" {
+	if p := m["multi_dest"]; len(p) != 2 || p[0].Path.String() != "/dest1" || p[1].Path.String() != "/dest2" {
 		t.Errorf("Expected two mappings but got '%s' in Darwin", p)
 	}
 }
@@ -215,7 +261,7 @@ func TestGetMappingsPlatformSpecificMappingsJson(t *testing.T) {
 	}
 
 	// Note: Consider '~' prefix in JSON path value
-	if !strings.HasSuffix(m[".vimrc"][0].String(), defaultMappings["windows"][".vimrc"][0][1:]) {
+	if !strings.HasSuffix(m[".vimrc"][0].Path.String(), defaultMappings["windows"][".vimrc"][0][1:]) {
 		t.Errorf("Mapping should not be overridden by mappings_darwin.json on different platform (Windows) but actually '%s'", m[".vimrc"][0])
 	}
 }
@@ -259,7 +305,7 @@ func TestGetMappingsPlatformSpecificMappingsJsonUnix(t *testing.T) {
 	}
 
 	// Note: Consider '~' prefix in JSON path value
-	if !strings.HasSuffix(m[".vimrc"][0].String(), defaultMappings["windows"][".vimrc"][0][1:]) {
+	if !strings.HasSuffix(m[".vimrc"][0].Path.String(), defaultMappings["windows"][".vimrc"][0][1:]) {
 		t.Errorf("Mapping should not be overridden by mappings_unix.json or mappings_darwin.json on different platform (Windows) but actually '%s'", m[".vimrc"][0])
 	}
 }
@@ -325,7 +371,7 @@ func TestLinkNormalFile(t *testing.T) {
 		defer os.Remove("._test_source.conf")
 	}()
 
-	err := m.CreateAllLinks(cwd, false)
+	err := m.CreateAllLinks(cwd, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -336,7 +382,7 @@ func TestLinkNormalFile(t *testing.T) {
 	defer os.Remove("_test.conf")
 
 	// Skipping already existing link
-	err = m.CreateAllLinks(cwd, false)
+	err = m.CreateAllLinks(cwd, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -351,7 +397,7 @@ func TestLinkToNonExistingDir(t *testing.T) {
 		defer os.Remove("._source.conf")
 	}()
 
-	err := m.CreateAllLinks(cwd, false)
+	err := m.CreateAllLinks(cwd, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -370,7 +416,7 @@ func TestLinkDirSymlink(t *testing.T) {
 	}
 	defer os.Remove("._source_dir")
 
-	err := m.CreateAllLinks(cwd, false)
+	err := m.CreateAllLinks(cwd, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -384,8 +430,8 @@ func TestLinkDirSymlink(t *testing.T) {
 func TestLinkSpecifiedMappingOnly(t *testing.T) {
 	cwd := getcwd()
 	m := mapping("._source.conf", "_dist.conf")
-	m["LICENSE.txt"] = []abspath.AbsPath{
-		getcwd().Join("_never_created.txt"),
+	m["LICENSE.txt"] = []Destination{
+		{Path: getcwd().Join("_never_created.txt"), Origin: "mappings.json"},
 	}
 	f := openFile("._source.conf")
 	defer func() {
@@ -393,7 +439,7 @@ func TestLinkSpecifiedMappingOnly(t *testing.T) {
 		os.Remove("._source.conf")
 	}()
 
-	err := m.CreateSomeLinks([]string{"._source.conf"}, cwd, false)
+	err := m.CreateSomeLinks([]string{"._source.conf"}, cwd, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -412,7 +458,7 @@ func TestLinkSpecifyingNonExistingFile(t *testing.T) {
 	cwd := getcwd()
 	m := mapping("LICENSE.txt", "never_created.conf")
 
-	err := m.CreateSomeLinks([]string{}, cwd, false)
+	err := m.CreateSomeLinks([]string{}, cwd, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -421,7 +467,7 @@ func TestLinkSpecifyingNonExistingFile(t *testing.T) {
 		os.Remove("never_created.conf")
 	}
 
-	err = m.CreateSomeLinks([]string{"unknown_config.conf"}, cwd, false)
+	err = m.CreateSomeLinks([]string{"unknown_config.conf"}, cwd, LinkOptions{})
 	if _, ok := err.(*NothingLinkedError); !ok {
 		t.Fatal(err)
 	}
@@ -434,12 +480,12 @@ func TestLinkSpecifyingNonExistingFile(t *testing.T) {
 func TestLinkSourceNotExist(t *testing.T) {
 	cwd := getcwd()
 	m := mapping(".unknown.conf", "never_created.conf")
-	err := m.CreateAllLinks(cwd, false)
+	err := m.CreateAllLinks(cwd, LinkOptions{})
 	if _, ok := err.(*NothingLinkedError); !ok {
 		t.Errorf("Not existing file must be ignored but actually error occurred: %s", err.Error())
 	}
 	m2 := mapping("unknown.conf", "never_created.conf")
-	err = m2.CreateSomeLinks([]string{"unknown.conf"}, cwd, false)
+	err = m2.CreateSomeLinks([]string{"unknown.conf"}, cwd, LinkOptions{})
 	if _, ok := err.(*NothingLinkedError); !ok {
 		t.Errorf("Not existing file must be ignored but actually error occurred: %s", err.Error())
 	}
@@ -448,10 +494,10 @@ func TestLinkSourceNotExist(t *testing.T) {
 func TestLinkNullDest(t *testing.T) {
 	cwd := getcwd()
 	m := Mappings{
-		"empty":     []abspath.AbsPath{},
-		"null_only": []abspath.AbsPath{abspath.AbsPath{}},
+		"empty":     []Destination{},
+		"null_only": []Destination{Destination{}},
 	}
-	err := m.CreateAllLinks(cwd, false)
+	err := m.CreateAllLinks(cwd, LinkOptions{})
 	if err == nil {
 		t.Errorf("Nothing was linked but error did not occur")
 	}
@@ -466,7 +512,7 @@ func TestLinkDryRun(t *testing.T) {
 		defer os.Remove("._test_source.conf")
 	}()
 
-	err := m.CreateAllLinks(cwd, true)
+	err := m.CreateAllLinks(cwd, LinkOptions{Dry: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -566,13 +612,13 @@ func TestActualLinksLinkExists(t *testing.T) {
 		t.Fatalf("Only one mapping is intended to be added but actually %d mappings exist", len(l))
 	}
 
-	if l[0].src != cwd.Join("._source.conf").String() {
+	if l[0].Src != cwd.Join("._source.conf").String() {
 		t.Fatalf("._source.conf in current directory must be a source of symlink but actually not: '%v'", l)
 	}
 
 	expected := cwd.Join("._dist.conf").String()
-	if l[0].dst != expected {
-		t.Fatalf("'%s' is expected as a dist of symlink, but actually '%s'", expected, l[0].dst)
+	if l[0].Dst != expected {
+		t.Fatalf("'%s' is expected as a dist of symlink, but actually '%s'", expected, l[0].Dst)
 	}
 }
 
@@ -603,7 +649,7 @@ func TestActualLinksTwoDestsFromOneSource(t *testing.T) {
 	defer os.Remove("._dest2.conf")
 	cwd := getcwd()
 	m := Mappings{
-		"._source.conf": []abspath.AbsPath{getcwd().Join("._dest1.conf"), getcwd().Join("._dest2.conf")},
+		"._source.conf": []Destination{{Path: getcwd().Join("._dest1.conf"), Origin: "mappings.json"}, {Path: getcwd().Join("._dest2.conf"), Origin: "mappings.json"}},
 	}
 
 	links, err := m.ActualLinks(cwd)
@@ -620,7 +666,7 @@ func TestActualLinksTwoDestsFromOneSource(t *testing.T) {
 
 	// `links` is generated from map. Order of elements in map is randomized. Adjust order of
 	// `expected` here
-	if strings.HasSuffix(links[0].dst, "._dest2.conf") {
+	if strings.HasSuffix(links[0].Dst, "._dest2.conf") {
 		// Swap order of `expected`
 		tmp := expected[0]
 		expected[0] = expected[1]
@@ -629,12 +675,12 @@ func TestActualLinksTwoDestsFromOneSource(t *testing.T) {
 
 	for i, c := range expected {
 		l := links[i]
-		if l.src != src {
-			t.Fatalf("Wanted %+v but got %+v for source (index=%d)", src, l.src, i)
+		if l.Src != src {
+			t.Fatalf("Wanted %+v but got %+v for source (index=%d)", src, l.Src, i)
 		}
 		dst := cwd.Join(c).String()
-		if l.dst != dst {
-			t.Fatalf("Wanted %+v but got %+v for source (index=%d)", dst, l.dst, i)
+		if l.Dst != dst {
+			t.Fatalf("Wanted %+v but got %+v for source (index=%d)", dst, l.Dst, i)
 		}
 	}
 }
@@ -644,7 +690,7 @@ func TestConvertMappingsJSONToMappings(t *testing.T) {
 		"empty":     []string{},
 		"null_only": []string{""},
 	}
-	m, err := convertMappingsJSONToMappings(json)
+	m, err := convertMappingsJSONToMappings(json, "mappings.json")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -669,7 +715,7 @@ func TestLinkOutsideDir(t *testing.T) {
 	openFile(p).Close()
 
 	d := cwd.Join(testDir)
-	err := m.CreateAllLinks(d, false)
+	err := m.CreateAllLinks(d, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -680,8 +726,535 @@ func TestLinkOutsideDir(t *testing.T) {
 	}
 
 	// Skipping already existing link
-	err = m.CreateAllLinks(d, false)
+	err = m.CreateAllLinks(d, LinkOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestRestoreAnotherFileAlreadyExist(t *testing.T) {
+	openFile("._dummy.conf").Close()
+	defer os.Remove("._dummy.conf")
+	m := mapping("._source.fonf", "._dummy.conf")
+	if err := m.Restore(getcwd(), nil, false); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Lstat("._dummy.conf"); err != nil {
+		t.Fatalf("File not put by this command must not be removed: %s", err)
+	}
+}
+
+func TestGetMappingsYAMLAndTOML(t *testing.T) {
+	testDir := createTestJSON("mappings.yaml", `
+# Comments are available in YAML
+some_file: /path/to/some_file
+multi_dest:
+  - /dest1
+  - /dest2
+`)
+	createTestJSON("mappings_darwin.toml", `
+# Comments are available in TOML
+".vimrc" = "/override/path/vimrc"
+"toml_multi" = ["/dest3", "/dest4"]
+`)
+	defer os.RemoveAll(testDir)
+
+	p, err := abspath.ExpandFrom(testDir)
+	if err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("darwin", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasOnlyDestination(m, "some_file", "/path/to/some_file") {
+		t.Errorf("Mapping value set in mappings.yaml is wrong: '%v'", m["some_file"])
+	}
+	if p := m["multi_dest"]; len(p) != 2 || p[0].Path.String() != "/dest1" || p[1].Path.String() != "/dest2" {
+		t.Errorf("Expected two mappings but got '%v'", p)
+	}
+	if !hasOnlyDestination(m, ".vimrc", "/override/path/vimrc") {
+		t.Errorf("Mapping should be overridden by mappings_darwin.toml but actually '%v'", m[".vimrc"])
+	}
+	if p := m["toml_multi"]; len(p) != 2 || p[0].Path.String() != "/dest3" || p[1].Path.String() != "/dest4" {
+		t.Errorf("Expected two mappings but got '%v'", p)
+	}
+	if o := m["some_file"][0].Origin; o != "mappings.yaml" {
+		t.Errorf("Origin should be file name but got '%s'", o)
+	}
+}
+
+func TestGetMappingsFormatPrecedence(t *testing.T) {
+	testDir := createTestJSON("mappings.json", `{"some_file": "/from/json"}`)
+	createTestJSON("mappings.yml", `some_file: /from/yaml`)
+	createTestJSON("mappings.toml", `some_file = "/from/toml"`)
+	defer os.RemoveAll(testDir)
+
+	p, err := abspath.ExpandFrom(testDir)
+	if err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasOnlyDestination(m, "some_file", "/from/json") {
+		t.Errorf("mappings.json should take precedence but actually '%v'", m["some_file"])
+	}
+
+	if err := os.Remove(filepath.Join(testDir, "mappings.json")); err != nil {
+		panic(err)
+	}
+	m, err = GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasOnlyDestination(m, "some_file", "/from/yaml") {
+		t.Errorf("mappings.yml should take precedence over mappings.toml but actually '%v'", m["some_file"])
+	}
+}
+
+func TestGetMappingsParseErrorLine(t *testing.T) {
+	testCases := []struct {
+		file    string
+		content string
+		line    int
+	}{
+		{"mappings.json", "{\n  \"foo\": \"/foo\",\n  \"bar\":\n}", 4},
+		{"mappings.yaml", "foo: /foo\nbar: baz: /bar\n", 2},
+		{"mappings.yaml", "foo: /foo\nbar:\n  - 42\n", 3},
+		{"mappings.toml", "foo = \"/foo\"\nbar = [1, \n", 2},
+		{"mappings.json", "{\"a\":\"~/.a\",\n \"b\": 42}", 2},
+		{"mappings.json", "{\n  \"a\": {\"dst\": \"~/.a\"},\n  \"b\":\n    [\"~/.b\", 42]\n}", 4},
+		{"mappings.toml", "a = \"~/.a\"\nb = 42\n", 2},
+		{"mappings.toml", "# comment\na = [\"~/.a\"]\n\n\"b.c\" = { dst = 42 }\n", 4},
+	}
+
+	for _, tc := range testCases {
+		testDir := createTestJSON(tc.file, tc.content)
+		p, err := abspath.ExpandFrom(testDir)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = GetMappingsForPlatform("unknown", p)
+		os.RemoveAll(testDir)
+
+		e, ok := err.(*MappingsFileError)
+		if !ok {
+			t.Errorf("Parse error for %s was expected but got %v", tc.file, err)
+			continue
+		}
+		if !strings.HasSuffix(e.File, tc.file) {
+			t.Errorf("Error should name the file %s: %s", tc.file, e.Error())
+		}
+		if e.Line != tc.line {
+			t.Errorf("Error should point line %d of %s but got %d: %s", tc.line, tc.file, e.Line, e.Error())
+		}
+	}
+}
+
+func TestGetMappingsHostUserProfileLayers(t *testing.T) {
+	host := hostnames()
+	if len(host) == 0 {
+		t.Skip("Hostname is not available")
+	}
+	user := username()
+	if user == "" {
+		t.Skip("User name is not available")
+	}
+
+	testDir := createTestJSON("mappings.json", `
+	{
+		"host_file": "/from/mappings",
+		"user_file": "/from/mappings",
+		"profile_file": "/from/mappings"
+	}
+	`)
+	createTestJSON("mappings_host_"+host[0]+".json", `
+	{
+		"host_file": "/from/host",
+		"user_file": "/from/host",
+		"profile_file": "/from/host"
+	}
+	`)
+	createTestJSON("mappings_user_"+user+".yaml", `
+user_file: /from/user
+profile_file: /from/user
+`)
+	createTestJSON("mappings_profile_work.json", `{"profile_file": "/from/profile"}`)
+	defer os.RemoveAll(testDir)
+
+	p, err := abspath.ExpandFrom(testDir)
+	if err != nil {
+		panic(err)
+	}
+
+	os.Setenv("DOTFILES_PROFILE", "work")
+	defer os.Unsetenv("DOTFILES_PROFILE")
+
+	m, err := GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		key    string
+		dest   string
+		origin string
+	}{
+		{"host_file", "/from/host", "mappings_host_" + host[0] + ".json"},
+		{"user_file", "/from/user", "mappings_user_" + user + ".yaml"},
+		{"profile_file", "/from/profile", "mappings_profile_work.json"},
+	} {
+		if !hasOnlyDestination(m, tc.key, tc.dest) {
+			t.Errorf("Wanted %s for %s but got %v", tc.dest, tc.key, m[tc.key])
+			continue
+		}
+		if o := m[tc.key][0].Origin; o != tc.origin {
+			t.Errorf("Wanted origin %s for %s but got %s", tc.origin, tc.key, o)
+		}
+	}
+
+	os.Unsetenv("DOTFILES_PROFILE")
+	m, err = GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasOnlyDestination(m, "profile_file", "/from/user") {
+		t.Errorf("Profile mappings must not be loaded without $DOTFILES_PROFILE: %v", m["profile_file"])
+	}
+}
+
+func TestGetMappingsConditionalValue(t *testing.T) {
+	testDir := createTestJSON("mappings.json", `
+	{
+		"foo": {"dst": "/path/to/foo", "when": {"os": "linux", "hostname": "build-*", "env": {"CI": "true"}, "exists": "/usr/bin/nvim"}},
+		"bar": ["/path/to/bar1", {"dst": "/path/to/bar2", "when": {"os": "darwin"}}]
+	}
+	`)
+	defer os.RemoveAll(testDir)
+
+	p, err := abspath.ExpandFrom(testDir)
+	if err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasOnlyDestination(m, "foo", "/path/to/foo") {
+		t.Fatalf("Destination of object value is wrong: %v", m["foo"])
+	}
+	c := m["foo"][0].When
+	if c == nil || c.OS != "linux" || c.Hostname != "build-*" || c.Env["CI"] != "true" || c.Exists != "/usr/bin/nvim" {
+		t.Fatalf("Condition is wrong: %+v", c)
+	}
+
+	bar := m["bar"]
+	if len(bar) != 2 || bar[0].When != nil || bar[1].When == nil || bar[1].When.OS != "darwin" {
+		t.Fatalf("String and object values should be mixed in array: %+v", bar)
+	}
+}
+
+func TestGetMappingsInvalidConditionalValue(t *testing.T) {
+	for _, input := range []string{
+		`{"foo": {"when": {"os": "linux"}}}`,
+		`{"foo": {"dst": "/foo", "when": {"unknown": "linux"}}}`,
+		`{"foo": {"dst": "/foo", "unknown": 42}}`,
+		`{"foo": 42}`,
+		`{"foo": ["/foo", true]}`,
+		`{"foo": {"dst": "/foo", "mode": "move"}}`,
+		`{"foo": {"dst": "/foo", "mode": "copy", "relative": true}}`,
+	} {
+		testDir := createTestJSON("mappings.json", input)
+		p, err := abspath.ExpandFrom(testDir)
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetMappingsForPlatform("unknown", p)
+		os.RemoveAll(testDir)
+		if _, ok := err.(*MappingsFileError); !ok {
+			t.Errorf("Invalid value %s should cause an error but got %v", input, err)
+		}
+	}
+}
+
+func TestLinkConditionNotSatisfied(t *testing.T) {
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	m["._test_source.conf"][0].When = &Condition{OS: "unknown"}
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+
+	err := m.CreateAllLinks(cwd, LinkOptions{})
+	if _, ok := err.(*NothingLinkedError); !ok {
+		t.Fatalf("Nothing should be linked when condition is not satisfied: %v", err)
+	}
+	if _, err := os.Lstat("_test.conf"); err == nil {
+		os.Remove("_test.conf")
+		t.Fatalf("Link was created though condition is not satisfied")
+	}
+}
+
+func TestLinkCopyMode(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	m["._test_source.conf"][0].Mode = ModeCopy
+	writeFile("._test_source.conf", "foo")
+	defer os.Remove("._test_source.conf")
+	defer os.Remove("_test.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := os.Lstat("_test.conf"); err != nil || s.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("File should be copied: %v", err)
+	}
+	if readFile("_test.conf") != "foo" {
+		t.Fatalf("Content should be copied")
+	}
+
+	// Outdated copy is replaced when the source is updated
+	writeFile("._test_source.conf", "bar")
+	sts, err := m.Status(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusOutdated {
+		t.Fatalf("Copy should be outdated: %+v", sts)
+	}
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile("_test.conf") != "bar" {
+		t.Fatalf("Outdated copy should be replaced")
+	}
+
+	links, err := m.ActualLinks(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].Mode != ModeCopy {
+		t.Fatalf("Copied file should be listed: %v", links)
+	}
+
+	if err := m.UnlinkAll(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat("_test.conf"); err == nil {
+		t.Fatalf("Copied file should be removed by clean")
+	}
+}
+
+func TestLinkCopyModeModifiedByUser(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	m["._test_source.conf"][0].Mode = ModeCopy
+	writeFile("._test_source.conf", "foo")
+	defer os.Remove("._test_source.conf")
+	defer os.Remove("_test.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile("_test.conf", "modified")
+	writeFile("._test_source.conf", "bar")
+
+	sts, err := m.Status(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusBlocked {
+		t.Fatalf("Modified copy should be blocked: %+v", sts)
+	}
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err == nil {
+		t.Fatalf("Modified copy should not be replaced")
+	}
+	if err := m.UnlinkAll(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if readFile("_test.conf") != "modified" {
+		t.Fatalf("Modified copy should not be removed")
+	}
+}
+
+func TestLinkHardlinkMode(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	m["._test_source.conf"][0].Mode = ModeHardlink
+	writeFile("._test_source.conf", "foo")
+	defer os.Remove("._test_source.conf")
+	defer os.Remove("_test.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !sameFile("._test_source.conf", "_test.conf") {
+		t.Fatalf("Hard link should be put")
+	}
+
+	sts, err := m.Status(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusLinked {
+		t.Fatalf("Hard link should be linked: %+v", sts)
+	}
+
+	if err := m.UnlinkAll(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat("_test.conf"); err == nil {
+		t.Fatalf("Hard link should be removed by clean")
+	}
+	if _, err := os.Stat("._test_source.conf"); err != nil {
+		t.Fatalf("Source should not be removed: %s", err)
+	}
+}
+
+func TestLinkRelative(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	cwd := getcwd()
+	m := Mappings{
+		"._test_source.conf": []Destination{{Path: cwd.Join("_test_dir", "_test.conf"), Origin: "mappings.json"}},
+	}
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+	defer os.RemoveAll("_test_dir")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Relative: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := os.Readlink(filepath.Join("_test_dir", "_test.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("..", "._test_source.conf"); target != want {
+		t.Fatalf("Relative link should be put: wanted '%s' but got '%s'", want, target)
+	}
+
+	links, err := m.ActualLinks(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].Src != cwd.Join("._test_source.conf").String() {
+		t.Fatalf("Relative link should be resolved: %v", links)
+	}
+
+	sts, err := m.Status(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusLinked {
+		t.Fatalf("Relative link should be linked: %+v", sts)
+	}
+
+	if err := m.UnlinkAll(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join("_test_dir", "_test.conf")); err == nil {
+		t.Fatalf("Relative link should be removed by clean")
+	}
+}
+
+func TestGetMappingsRelativeValue(t *testing.T) {
+	testDir := createTestJSON("mappings.json", `{"foo": {"dst": "/foo", "relative": true}}`)
+	defer os.RemoveAll(testDir)
+	p, err := abspath.ExpandFrom(testDir)
+	if err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("unknown", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds := m["foo"]; len(ds) != 1 || !ds[0].Relative {
+		t.Fatalf("Relative option should be set: %v", ds)
+	}
+}
+
+func TestGetMappingsSuppressDefault(t *testing.T) {
+	testDir := createTestJSON("mappings.json", `
+	{
+		".vimrc": null,
+		"vimrc": [],
+		"some_file": "/path/to/some_file"
+	}
+	`)
+	defer os.RemoveAll(testDir)
+
+	p, err := abspath.ExpandFrom(testDir)
+	if err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("darwin", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{".vimrc", "vimrc"} {
+		if _, ok := m[k]; ok {
+			t.Errorf("Default mapping of '%s' should be suppressed: %v", k, m[k])
+		}
+	}
+	if len(m[".zshrc"]) == 0 {
+		t.Errorf("Other default mappings should be kept")
+	}
+	if !hasOnlyDestination(m, "some_file", "/path/to/some_file") {
+		t.Errorf("Mapping in mappings.json should be kept: %v", m["some_file"])
+	}
+}
+
+func TestGetMappingsWithoutDefault(t *testing.T) {
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+	dir := repo.Join(".dotfiles")
+	if err := os.Mkdir(dir.String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(dir.Join("mappings.json").String(), `{"some_file": "/path/to/some_file"}`)
+
+	SetDefaultMappingsEnabled(false)
+	m, err := GetMappingsForPlatform("darwin", dir)
+	SetDefaultMappingsEnabled(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || !hasOnlyDestination(m, "some_file", "/path/to/some_file") {
+		t.Errorf("Only mappings in mappings.json should be used: %v", m)
+	}
+
+	writeFile(dir.Join("config.json").String(), `{"defaults": false}`)
+	m, err = GetMappingsForPlatform("darwin", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || !hasOnlyDestination(m, "some_file", "/path/to/some_file") {
+		t.Errorf("Default mappings should be disabled by config: %v", m)
+	}
+
+	writeFile(dir.Join("config.json").String(), `{"defaults": true}`)
+	m, err = GetMappingsForPlatform("darwin", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m[".vimrc"]) == 0 {
+		t.Errorf("Default mappings should be enabled by config: %v", m)
+	}
+}
@@ -0,0 +1,238 @@
+package dotfiles
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rhysd/abspath"
+)
+
+// LinkMode is a way to put a source file at its destination
+type LinkMode string
+
+const (
+	// ModeSymlink puts a symbolic link to the source. This is the default
+	ModeSymlink LinkMode = "symlink"
+	// ModeCopy copies the source. Copied files are managed by their checksums recorded in manifest
+	ModeCopy LinkMode = "copy"
+	// ModeHardlink puts a hard link to the source. It is not available for directories
+	ModeHardlink LinkMode = "hardlink"
+	// ModeTemplate renders the source as a template and writes the output. Like ModeCopy, rendered files
+	// are managed by their checksums
+	ModeTemplate LinkMode = "template"
+	// ModeDecrypt decrypts the source encrypted with age and writes the output with 0600 permission
+	ModeDecrypt LinkMode = "decrypt"
+)
+
+func (m LinkMode) validate() error {
+	switch m {
+	case "", ModeSymlink, ModeCopy, ModeHardlink, ModeTemplate, ModeDecrypt:
+		return nil
+	default:
+		return fmt.Errorf("\"mode\" must be one of \"symlink\", \"copy\", \"hardlink\", \"template\" or \"decrypt\" but got %q", string(m))
+	}
+}
+
+func (m LinkMode) isSymlink() bool {
+	return m == "" || m == ModeSymlink
+}
+
+// label returns a label of the action to put a file with the mode in text output
+func (m LinkMode) label() string {
+	switch m {
+	case ModeCopy:
+		return "Copy: "
+	case ModeHardlink:
+		return "Hardlink:"
+	case ModeTemplate:
+		return "Render:"
+	case ModeDecrypt:
+		return "Decrypt:"
+	default:
+		return "Link: "
+	}
+}
+
+// checksumOf returns SHA-256 checksum of the file. For a directory, all files in it are hashed with their
+// relative paths.
+func checksumOf(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			s, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", s)
+		case info.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sameFile(a, b string) bool {
+	sa, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(sa, sb)
+}
+
+// put puts the source at the destination with the mode. When relative is true, a symbolic link has a
+// relative path from the directory of the destination.
+func (m LinkMode) put(from, to string, relative bool) error {
+	switch m {
+	case ModeCopy:
+		s, err := os.Stat(from)
+		if err != nil {
+			return err
+		}
+		if s.IsDir() {
+			return copyTree(from, to)
+		}
+		return copyFile(from, to, s.Mode().Perm())
+	case ModeHardlink:
+		return os.Link(from, to)
+	default:
+		// Note: Link under the root directory points to the path seen from the root
+		from = inRoot(from)
+		if relative {
+			rel, err := filepath.Rel(inRoot(filepath.Dir(to)), from)
+			if err != nil {
+				return err
+			}
+			from = rel
+		}
+		return os.Symlink(from, to)
+	}
+}
+
+// isPut returns true when the destination is the source put with the mode
+func (m LinkMode) isPut(from, to string) bool {
+	switch m {
+	case ModeCopy:
+		if s, err := os.Lstat(to); err != nil || s.Mode()&os.ModeSymlink != 0 {
+			return false
+		}
+		c1, err := checksumOf(from)
+		if err != nil {
+			return false
+		}
+		c2, err := checksumOf(to)
+		return err == nil && c1 == c2
+	case ModeHardlink:
+		return sameFile(from, to)
+	default:
+		s, err := readLink(to)
+		return err == nil && s == from
+	}
+}
+
+// defaultModeFor returns the mode of the destination for the source. Sources ending with .tmpl are
+// rendered and sources ending with .age are decrypted when no mode is specified.
+func defaultModeFor(src string, d Destination) LinkMode {
+	if d.Mode != "" {
+		return d.Mode
+	}
+	switch {
+	case strings.HasSuffix(src, templateExt):
+		return ModeTemplate
+	case strings.HasSuffix(src, encryptedExt):
+		return ModeDecrypt
+	default:
+		return ""
+	}
+}
+
+// trimExt removes the extension which determines the mode from the destination path
+func (m LinkMode) trimExt(rel string) string {
+	switch m {
+	case ModeTemplate:
+		return strings.TrimSuffix(rel, templateExt)
+	case ModeDecrypt:
+		return strings.TrimSuffix(rel, encryptedExt)
+	default:
+		return rel
+	}
+}
+
+// isGenerated returns true when the contents of destinations are generated from their sources
+func (m LinkMode) isGenerated() bool {
+	return m == ModeTemplate || m == ModeDecrypt
+}
+
+// generator generates contents of destinations for the modes which do not put sources as-is
+type generator struct {
+	renderer  *templateRenderer
+	decrypter *decrypter
+}
+
+func newGenerator(repo abspath.AbsPath) *generator {
+	return &generator{&templateRenderer{repo: repo}, &decrypter{}}
+}
+
+func (g *generator) generate(src string, m LinkMode) ([]byte, os.FileMode, error) {
+	if m == ModeDecrypt {
+		b, err := g.decrypter.decrypt(src)
+		return b, secretPerm, err
+	}
+
+	s, err := os.Stat(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := g.renderer.render(src)
+	return b, s.Mode().Perm(), err
+}
+
+// hasContent returns true when the destination is a file which has the content
+func hasContent(content []byte, dst string) bool {
+	if s, err := os.Lstat(dst); err != nil || !s.Mode().IsRegular() {
+		return false
+	}
+	b, err := ioutil.ReadFile(dst)
+	return err == nil && bytes.Equal(b, content)
+}
+
+// writeGenerated writes the generated content to the destination with the permission
+func writeGenerated(content []byte, dst string, perm os.FileMode) error {
+	if err := ioutil.WriteFile(dst, content, perm); err != nil {
+		return err
+	}
+	// Note: Permission is not changed by WriteFile when the file already exists
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,78 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkModeValidate(t *testing.T) {
+	for _, m := range []LinkMode{"", ModeSymlink, ModeCopy, ModeHardlink} {
+		if err := m.validate(); err != nil {
+			t.Errorf("Mode %q should be valid: %s", m, err)
+		}
+	}
+	if err := LinkMode("move").validate(); err == nil {
+		t.Errorf("Unknown mode should be invalid")
+	}
+}
+
+func TestChecksumOf(t *testing.T) {
+	writeFile("._test_checksum", "foo")
+	defer os.Remove("._test_checksum")
+
+	c1, err := checksumOf("._test_checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile("._test_checksum", "bar")
+	c2, err := checksumOf("._test_checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 == c2 {
+		t.Errorf("Checksum should be changed when content is changed")
+	}
+
+	if err := os.MkdirAll(filepath.Join("._test_checksum_dir", "sub"), os.ModePerm); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll("._test_checksum_dir")
+	writeFile(filepath.Join("._test_checksum_dir", "sub", "file"), "foo")
+
+	d1, err := checksumOf("._test_checksum_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyTree("._test_checksum_dir", "._test_checksum_dir2"); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll("._test_checksum_dir2")
+	d2, err := checksumOf("._test_checksum_dir2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1 != d2 {
+		t.Errorf("Checksums of copied directories should be equal: %s vs %s", d1, d2)
+	}
+}
+
+func TestLinkModePutAndIsPut(t *testing.T) {
+	writeFile("._test_mode_source", "foo")
+	defer os.Remove("._test_mode_source")
+	src := getcwd().Join("._test_mode_source").String()
+
+	for _, m := range []LinkMode{ModeSymlink, ModeCopy, ModeHardlink} {
+		dst := getcwd().Join("._test_mode_dest").String()
+		if m.isPut(src, dst) {
+			t.Errorf("Nothing is put with mode %s yet", m)
+		}
+		if err := m.put(src, dst, false); err != nil {
+			t.Fatal(err)
+		}
+		if !m.isPut(src, dst) {
+			t.Errorf("File should be put with mode %s", m)
+		}
+		os.Remove(dst)
+	}
+}
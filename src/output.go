@@ -0,0 +1,143 @@
+package dotfiles
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fatih/color"
+)
+
+// OutputFormat is a format of output of commands
+type OutputFormat string
+
+const (
+	// FormatText outputs human readable text
+	FormatText OutputFormat = "text"
+	// FormatJSON outputs one JSON object which contains all actions and a summary of them
+	FormatJSON OutputFormat = "json"
+)
+
+// Results of Action
+const (
+	ResultDone    = "done"
+	ResultDryRun  = "dry-run"
+	ResultExists  = "exists"
+	ResultSkipped = "skipped"
+	ResultFailed  = "failed"
+)
+
+func dryResult(dry bool) string {
+	if dry {
+		return ResultDryRun
+	}
+	return ResultDone
+}
+
+// Action is one operation done by a command or one link found by a command
+type Action struct {
+	Kind        string `json:"kind"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	Mapping     string `json:"mapping,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	Result      string `json:"result"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Summary is put at the end of JSON output
+type Summary struct {
+	Total   int            `json:"total"`
+	Results map[string]int `json:"results"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type outputJSON struct {
+	Actions []Action `json:"actions"`
+	Summary Summary  `json:"summary"`
+}
+
+type reporter struct {
+	format  OutputFormat
+	actions []Action
+	// changed is destinations of links put or removed so far. They are passed to hooks
+	changed []string
+}
+
+var output = &reporter{format: FormatText}
+
+func SetOutputFormat(f OutputFormat) {
+	if f == "" {
+		f = FormatText
+	}
+	output = &reporter{format: f}
+}
+
+// action reports the action. The text is output only when the format is text. When c is nil, the text
+// is output without color.
+func (r *reporter) action(a Action, c *color.Color, text string, args ...interface{}) {
+	if (a.Kind == "link" || a.Kind == "unlink") && a.Result == ResultDone {
+		r.changed = append(r.changed, a.Destination)
+	}
+	if r.format != FormatJSON {
+		if c == nil {
+			fmt.Printf(text, args...)
+		} else {
+			c.Printf(text, args...)
+		}
+		return
+	}
+	r.actions = append(r.actions, a)
+}
+
+// failed reports the action which failed due to the error. Nothing is output when the format is text
+// since the error is reported by the caller.
+func (r *reporter) failed(a Action, err error) {
+	if r.format != FormatJSON {
+		return
+	}
+	a.Result = ResultFailed
+	a.Error = err.Error()
+	r.actions = append(r.actions, a)
+}
+
+// message outputs the text only when the format is text
+func (r *reporter) message(text string, args ...interface{}) {
+	if r.format != FormatJSON {
+		fmt.Printf(text, args...)
+	}
+}
+
+// FlushOutput outputs all actions reported so far with a summary in JSON format. It does nothing when the
+// output format is text.
+func FlushOutput(err error) error {
+	r := output
+	if r.format != FormatJSON {
+		return nil
+	}
+
+	out := outputJSON{
+		Actions: r.actions,
+		Summary: Summary{
+			Total:   len(r.actions),
+			Results: map[string]int{},
+		},
+	}
+	if out.Actions == nil {
+		out.Actions = []Action{}
+	}
+	for _, a := range r.actions {
+		out.Summary.Results[a.Result]++
+	}
+	if err != nil {
+		out.Summary.Error = err.Error()
+	}
+	r.actions = nil
+
+	b, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(b))
+	return err
+}
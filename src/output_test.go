@@ -0,0 +1,100 @@
+package dotfiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestJSONOutput(t *testing.T) {
+	SetOutputFormat(FormatJSON)
+	defer SetOutputFormat(FormatText)
+
+	stdoutSaved := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdoutSaved
+	}()
+
+	cwd := getcwd()
+	m := mapping("._test_source.conf", "_test.conf")
+	openFile("._test_source.conf").Close()
+	defer os.Remove("._test_source.conf")
+
+	if err := m.CreateAllLinks(cwd, LinkOptions{Dry: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := FlushOutput(nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var out outputJSON
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("Output is not a valid JSON: %s: %s", err, buf.String())
+	}
+
+	if len(out.Actions) != 1 {
+		t.Fatalf("One action should be reported but got %v", out.Actions)
+	}
+	want := Action{
+		Kind:        "link",
+		Source:      cwd.Join("._test_source.conf").String(),
+		Destination: cwd.Join("_test.conf").String(),
+		Mapping:     "mappings.json",
+		Result:      ResultDryRun,
+	}
+	if out.Actions[0] != want {
+		t.Errorf("Wanted %v but got %v", want, out.Actions[0])
+	}
+	if out.Summary.Total != 1 || out.Summary.Results[ResultDryRun] != 1 {
+		t.Errorf("Summary is wrong: %v", out.Summary)
+	}
+}
+
+func TestJSONOutputWithError(t *testing.T) {
+	SetOutputFormat(FormatJSON)
+	defer SetOutputFormat(FormatText)
+
+	stdoutSaved := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdoutSaved
+	}()
+
+	if err := FlushOutput(&NothingLinkedError{}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var out outputJSON
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("Output is not a valid JSON: %s: %s", err, buf.String())
+	}
+	if out.Actions == nil || len(out.Actions) != 0 {
+		t.Errorf("Actions should be an empty array: %v", out.Actions)
+	}
+	if out.Summary.Error == "" {
+		t.Errorf("Error should be reported in summary")
+	}
+}
@@ -0,0 +1,251 @@
+package dotfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rhysd/abspath"
+)
+
+// packagesConfig is a configuration of package mode put in .dotfiles/packages.{json,yaml,yml,toml}. Like
+// GNU Stow, each package is a top-level directory of dotfiles repository and its contents are mirrored
+// into the target directory.
+type packagesConfig struct {
+	// Packages is a list of package directories. All top-level directories are packages when it is empty.
+	Packages []string `json:"packages"`
+	// Target is a directory where packages are mirrored. Default is home directory.
+	Target string `json:"target"`
+}
+
+func readPackagesConfig(file abspath.AbsPath) (*packagesConfig, error) {
+	c := &packagesConfig{}
+	if err := decodeConfigFile(file, c, "packages config"); err != nil {
+		return nil, err
+	}
+	if c.Target == "" {
+		c.Target = "~"
+	}
+
+	return c, nil
+}
+
+func (c *packagesConfig) names(repo abspath.AbsPath) ([]string, error) {
+	if len(c.Packages) > 0 {
+		for _, n := range c.Packages {
+			s, err := os.Stat(repo.Join(filepath.FromSlash(n)).String())
+			if err != nil || !s.IsDir() {
+				return nil, fmt.Errorf("package '%s' is not a directory in '%s'", n, repo.String())
+			}
+		}
+		return c.Packages, nil
+	}
+
+	entries, err := ioutil.ReadDir(repo.String())
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, e := range entries {
+		// Note: Hidden directories such as .git and .dotfiles are not packages
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			ret = append(ret, e.Name())
+		}
+	}
+	return ret, nil
+}
+
+type packageEntry struct {
+	pkg   string
+	isDir bool
+}
+
+type packageMapper struct {
+	repo   abspath.AbsPath
+	target abspath.AbsPath
+	origin string
+	maps   Mappings
+}
+
+func (m *packageMapper) add(pkg, rel string) {
+	k := path.Join(pkg, rel)
+	d := Destination{Origin: m.origin, Package: pkg}
+	d.Mode = defaultModeFor(k, d)
+	d.Path = m.target.Join(filepath.FromSlash(d.Mode.trimExt(rel)))
+	m.maps[k] = append(m.maps[k], d)
+}
+
+// linkedIntoRepo returns true when the path is a symlink to some file in the dotfiles repository
+func (m *packageMapper) linkedIntoRepo(p string) bool {
+	s, err := readLink(p)
+	return err == nil && strings.HasPrefix(s, m.repo.String()+string(filepath.Separator))
+}
+
+// fold returns true when the directory can be linked as a whole. The directory is unfolded into links
+// of its contents when a directory already exists at the target.
+func (m *packageMapper) fold(src string, dst string) (fold bool, viaRepo bool) {
+	s, err := os.Lstat(dst)
+	if err != nil {
+		return true, false
+	}
+	if s.Mode()&os.ModeSymlink == 0 {
+		// Note: When a file which is not a directory exists, let the conflict strategy handle it
+		return !s.IsDir(), false
+	}
+	if t, _ := readLink(dst); t == src {
+		return true, false
+	}
+	if m.linkedIntoRepo(dst) {
+		// Note: Folded link of other package. It will be unfolded on linking
+		return false, true
+	}
+	if s, err := os.Stat(dst); err == nil && s.IsDir() {
+		return false, false
+	}
+	return true, false
+}
+
+// walk maps entries in the directory rel of the packages. viaRepo is true when the target directory is
+// actually a directory in the repository through a folded link. Then the target is regarded as missing.
+func (m *packageMapper) walk(pkgs []string, rel string, viaRepo bool) error {
+	children := map[string][]packageEntry{}
+	for _, pkg := range pkgs {
+		entries, err := ioutil.ReadDir(m.repo.Join(filepath.FromSlash(path.Join(pkg, rel))).String())
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Name() == ".git" {
+				continue
+			}
+			children[e.Name()] = append(children[e.Name()], packageEntry{pkg, e.IsDir()})
+		}
+	}
+
+	names := make([]string, 0, len(children))
+	for n := range children {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		entries := children[n]
+		crel := path.Join(rel, n)
+
+		dirs := []string{}
+		for _, e := range entries {
+			if e.isDir {
+				dirs = append(dirs, e.pkg)
+			} else {
+				m.add(e.pkg, crel)
+			}
+		}
+		if len(dirs) == 0 {
+			continue
+		}
+
+		dst := m.target.Join(filepath.FromSlash(crel)).String()
+		if len(dirs) == 1 && len(entries) == 1 {
+			src := m.repo.Join(filepath.FromSlash(path.Join(dirs[0], crel))).String()
+			fold, via := true, false
+			if !viaRepo {
+				fold, via = m.fold(src, dst)
+			}
+			if fold {
+				m.add(dirs[0], crel)
+				continue
+			}
+			if err := m.walk(dirs, crel, via); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Note: A directory shared by multiple packages is always unfolded
+		if err := m.walk(dirs, crel, viaRepo || m.linkedIntoRepo(dst)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func packageMappings(repo, dst abspath.AbsPath, pkgs []string, origin string) (Mappings, error) {
+	m := &packageMapper{repo, dst, origin, Mappings{}}
+	if err := m.walk(pkgs, "", false); err != nil {
+		return nil, err
+	}
+	return m.maps, nil
+}
+
+// mergeMappingsFromPackages merges mappings of package mode when packages config exists in the
+// directory. Explicit mappings take precedence over them.
+func mergeMappingsFromPackages(dist Mappings, dir abspath.AbsPath) error {
+	file, ok := findMappingsFile(dir, "packages")
+	if !ok {
+		return nil
+	}
+
+	c, err := readPackagesConfig(file)
+	if err != nil {
+		return err
+	}
+
+	t, err := expandEnv(c.Target)
+	if err != nil {
+		return &MappingsFileError{file.String(), 0, err}
+	}
+	dst, err := expandDestination(t)
+	if err != nil {
+		return err
+	}
+
+	repo := dir.Dir()
+	pkgs, err := c.names(repo)
+	if err != nil {
+		return &MappingsFileError{file.String(), 0, err}
+	}
+
+	m, err := packageMappings(repo, dst, pkgs, file.Base().String())
+	if err != nil {
+		return err
+	}
+
+	for k, ds := range m {
+		if _, ok := dist[k]; !ok {
+			dist[k] = ds
+		}
+	}
+
+	return nil
+}
+
+// planUnfold plans replacing a folded link to some directory in the repository in ancestors of the
+// destination with a real directory so that links of multiple packages can be put in the directory.
+func (l *linker) planUnfold(dst abspath.AbsPath) (Operation, bool) {
+	ancestors := []string{}
+	for d := dst.Dir().String(); d != filepath.Dir(d); d = filepath.Dir(d) {
+		ancestors = append(ancestors, d)
+	}
+
+	prefix := l.repo.String() + string(filepath.Separator)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		d := ancestors[i]
+		if l.dirs[d] {
+			continue
+		}
+		t, err := readLink(d)
+		if err != nil || !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		l.unfolded = append(l.unfolded, d)
+		l.dirs[d] = true
+		return Operation{Kind: OpUnfold, Source: t, Destination: d}, true
+	}
+
+	return Operation{}, false
+}
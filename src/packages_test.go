@@ -0,0 +1,192 @@
+package dotfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rhysd/abspath"
+)
+
+const (
+	testPackagesRepo = "_test_packages_repo"
+	testPackagesHome = "_test_packages_home"
+)
+
+func createPackagesRepo(config string) abspath.AbsPath {
+	for _, f := range []string{
+		"vim/.vimrc",
+		"vim/.vim/colors/foo.vim",
+		"git/.config/git/config",
+		"nvim/.config/nvim/init.vim",
+	} {
+		p := filepath.Join(testPackagesRepo, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			panic(err)
+		}
+		openFile(p).Close()
+	}
+	if err := os.MkdirAll(filepath.Join(testPackagesRepo, ".dotfiles"), os.ModePerm); err != nil {
+		panic(err)
+	}
+	writePackagesConfig(config)
+	if err := os.MkdirAll(testPackagesHome, os.ModePerm); err != nil {
+		panic(err)
+	}
+	return getcwd().Join(testPackagesRepo)
+}
+
+func writePackagesConfig(config string) {
+	p := filepath.Join(testPackagesRepo, ".dotfiles", "packages.json")
+	if err := ioutil.WriteFile(p, []byte(config), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func packagesConfigFor(pkgs string) string {
+	return fmt.Sprintf(`{"packages": %s, "target": "%s"}`, pkgs, getcwd().Join(testPackagesHome).String())
+}
+
+func cleanupPackages() {
+	os.RemoveAll(testPackagesRepo)
+	os.RemoveAll(testPackagesHome)
+}
+
+func checkPackageMappings(t *testing.T, m Mappings, want map[string]string) {
+	home := getcwd().Join(testPackagesHome)
+	if len(m) != len(want) {
+		t.Errorf("Wanted %d mappings but got %v", len(want), m)
+	}
+	for k, dst := range want {
+		ds, ok := m[k]
+		if !ok || len(ds) != 1 {
+			t.Errorf("Key '%s' should be mapped to one destination: %v", k, ds)
+			continue
+		}
+		if ds[0].Path.String() != home.Join(filepath.FromSlash(dst)).String() {
+			t.Errorf("Key '%s' should be mapped to '%s' but got '%s'", k, dst, ds[0].Path.String())
+		}
+		if ds[0].Origin != "packages.json" {
+			t.Errorf("Origin of '%s' should be packages.json: %s", k, ds[0].Origin)
+		}
+	}
+}
+
+func TestPackagesAllTopLevelDirectories(t *testing.T) {
+	repo := createPackagesRepo(packagesConfigFor("[]"))
+	defer cleanupPackages()
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkPackageMappings(t, m, map[string]string{
+		"vim/.vimrc":        ".vimrc",
+		"vim/.vim":          ".vim",
+		"git/.config/git":   ".config/git",
+		"nvim/.config/nvim": ".config/nvim",
+	})
+}
+
+func TestPackagesUnfoldExistingDirectory(t *testing.T) {
+	repo := createPackagesRepo(packagesConfigFor(`["vim"]`))
+	defer cleanupPackages()
+
+	if err := os.MkdirAll(filepath.Join(testPackagesHome, ".vim"), os.ModePerm); err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkPackageMappings(t, m, map[string]string{
+		"vim/.vimrc":      ".vimrc",
+		"vim/.vim/colors": ".vim/colors",
+	})
+}
+
+func TestPackagesUnfoldFoldedLinkOfOtherPackage(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	repo := createPackagesRepo(packagesConfigFor(`["git"]`))
+	defer cleanupPackages()
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !isSymlinkTo(filepath.Join(testPackagesHome, ".config"), filepath.Join(testPackagesRepo, "git", ".config")) {
+		t.Fatal(".config should be folded into one link")
+	}
+
+	writePackagesConfig(packagesConfigFor(`["git", "nvim"]`))
+	m, err = GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPackageMappings(t, m, map[string]string{
+		"git/.config/git":   ".config/git",
+		"nvim/.config/nvim": ".config/nvim",
+	})
+
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := os.Lstat(filepath.Join(testPackagesHome, ".config"))
+	if err != nil || s.Mode()&os.ModeSymlink != 0 || !s.IsDir() {
+		t.Fatalf(".config should be unfolded into a directory: %v", err)
+	}
+	for _, pkg := range []string{"git", "nvim"} {
+		dst := filepath.Join(testPackagesHome, ".config", pkg)
+		src := filepath.Join(testPackagesRepo, pkg, ".config", pkg)
+		if !isSymlinkTo(dst, src) {
+			t.Errorf("'%s' should be linked to '%s'", dst, src)
+		}
+	}
+}
+
+func TestPackagesExplicitMappingsTakePrecedence(t *testing.T) {
+	repo := createPackagesRepo(packagesConfigFor(`["vim"]`))
+	defer cleanupPackages()
+
+	f := filepath.Join(testPackagesRepo, ".dotfiles", "mappings.json")
+	if err := ioutil.WriteFile(f, []byte(`{"vim/.vimrc": "/path/to/vimrc"}`), 0644); err != nil {
+		panic(err)
+	}
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := m["vim/.vimrc"]
+	if len(ds) != 1 || ds[0].Path.String() != "/path/to/vimrc" || ds[0].Origin != "mappings.json" {
+		t.Errorf("Explicit mapping should take precedence: %v", ds)
+	}
+	if _, ok := m["vim/.vim"]; !ok {
+		t.Errorf("Other package mappings should be merged: %v", m)
+	}
+}
+
+func TestPackagesInvalidConfig(t *testing.T) {
+	for _, config := range []string{
+		`{"packages": ["unknown"]}`,
+		`{"unknown": true}`,
+	} {
+		repo := createPackagesRepo(config)
+		_, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+		cleanupPackages()
+		if _, ok := err.(*MappingsFileError); !ok {
+			t.Errorf("Invalid config %s should cause an error but got %v", config, err)
+		}
+	}
+}
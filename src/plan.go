@@ -0,0 +1,462 @@
+package dotfiles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/rhysd/abspath"
+)
+
+// OpKind is a kind of operation in a link plan
+type OpKind string
+
+const (
+	// OpMkdir creates a parent directory of a destination
+	OpMkdir OpKind = "mkdir"
+	// OpUnfold replaces a folded link to some directory in the repository with a real directory
+	OpUnfold OpKind = "unfold"
+	// OpBackup moves the existing file at a destination to a backup path
+	OpBackup OpKind = "backup"
+	// OpRemove removes the existing file at a destination
+	OpRemove OpKind = "remove"
+	// OpAdopt moves the existing file at a destination into the repository as the source
+	OpAdopt OpKind = "adopt"
+	// OpLink puts a source at a destination with the mode
+	OpLink OpKind = "link"
+	// OpExists records a source which was already put at a destination
+	OpExists OpKind = "exists"
+	// OpSkip does nothing for a mapping
+	OpSkip OpKind = "skip"
+)
+
+// Operation is one step of a link plan
+type Operation struct {
+	Kind        OpKind   `json:"kind"`
+	Source      string   `json:"source,omitempty"`
+	Destination string   `json:"destination"`
+	Mapping     string   `json:"mapping,omitempty"`
+	Mode        LinkMode `json:"mode,omitempty"`
+	// Relative puts a relative symbolic link
+	Relative bool `json:"relative,omitempty"`
+	// Backup is a path where the file existing at the destination was moved. It is recorded in manifest
+	Backup string `json:"backup,omitempty"`
+	// Reason is why the operation is planned. It is set to skip and remove operations
+	Reason string `json:"reason,omitempty"`
+}
+
+// action returns the action reported for the operation with the text output
+func (op Operation) action(result string) (Action, *color.Color, string, []interface{}) {
+	a := Action{Kind: string(op.Kind), Source: op.Source, Destination: op.Destination, Mapping: op.Mapping, Result: result, Reason: op.Reason}
+	yellow, cyan := color.New(color.FgYellow), color.New(color.FgCyan)
+	switch op.Kind {
+	case OpMkdir:
+		return a, nil, "Mkdir: '%s'\n", []interface{}{op.Destination}
+	case OpUnfold:
+		return a, cyan, "Unfold: '%s' -> '%s'\n", []interface{}{op.Source, op.Destination}
+	case OpBackup:
+		return a, yellow, "Backup: '%s' -> '%s'\n", []interface{}{op.Source, op.Destination}
+	case OpRemove:
+		if op.Reason == "overwrite" {
+			a.Kind = "overwrite"
+			return a, yellow, "Overwrite: '%s'\n", []interface{}{op.Destination}
+		}
+		return a, yellow, "Remove: '%s' (%s)\n", []interface{}{op.Destination, op.Reason}
+	case OpAdopt:
+		return a, yellow, "Adopt: '%s' -> '%s'\n", []interface{}{op.Source, op.Destination}
+	case OpExists:
+		a.Kind, a.Mode, a.Result = "link", string(op.Mode), ResultExists
+		return a, nil, "Exist: '%s' -> '%s'\n", []interface{}{op.Source, op.Destination}
+	case OpSkip:
+		a.Kind, a.Mode, a.Result = "link", string(op.Mode), ResultSkipped
+		return a, nil, "Skip:  '%s' -> '%s' (%s)\n", []interface{}{op.Source, op.Destination, op.Reason}
+	default:
+		a.Mode = string(op.Mode)
+		return a, cyan, "%s '%s' -> '%s'\n", []interface{}{op.Mode.label(), op.Source, op.Destination}
+	}
+}
+
+func (op Operation) report(result string) {
+	a, c, text, args := op.action(result)
+	output.action(a, c, text, args...)
+}
+
+// LinkPlan is an ordered list of operations to put links from dotfiles repository. It is made without
+// touching the filesystem and can be applied later.
+type LinkPlan struct {
+	Repository string `json:"repository"`
+	// Home and Root are the alternate home directory and root directory on planning
+	Home       string      `json:"home,omitempty"`
+	Root       string      `json:"root,omitempty"`
+	Operations []Operation `json:"operations"`
+}
+
+// exists returns true when the path exists after the operations planned so far are applied
+func (l *linker) exists(p string) bool {
+	if _, ok := l.planned[p]; ok || l.dirs[p] {
+		return true
+	}
+	for _, d := range l.unfolded {
+		// Note: Contents of the unfolded directory are not there after unfolding
+		if p != d && isInside(p, d) {
+			return false
+		}
+	}
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+func (l *linker) mkdir(dir string) Operation {
+	for d := dir; d != filepath.Dir(d); d = filepath.Dir(d) {
+		l.dirs[d] = true
+	}
+	return Operation{Kind: OpMkdir, Destination: dir}
+}
+
+// planConflict plans how to handle the existing file at destination. It returns false as the second
+// return value when the link should not be put.
+func (l *linker) planConflict(from abspath.AbsPath, to Destination, link Operation) (Operation, bool, error) {
+	dst := to.Path.String()
+
+	strategy, err := l.strategyFor(dst)
+	if err != nil {
+		return Operation{}, false, err
+	}
+
+	switch strategy {
+	case ConflictBackup:
+		return Operation{Kind: OpBackup, Source: dst, Destination: backupPathFor(dst)}, true, nil
+	case ConflictOverwrite:
+		return Operation{Kind: OpRemove, Destination: dst, Reason: "overwrite"}, true, nil
+	case ConflictAdopt:
+		if s, err := os.Lstat(dst); err == nil && s.Mode()&os.ModeSymlink != 0 {
+			link.Kind, link.Reason = OpSkip, "symbolic link cannot be adopted"
+			return link, false, nil
+		}
+		return Operation{Kind: OpAdopt, Source: dst, Destination: from.String()}, true, nil
+	default:
+		link.Kind, link.Reason = OpSkip, "destination already exists"
+		return link, false, nil
+	}
+}
+
+// plan plans operations to put the source at the destination. It returns true as the second return value
+// when the source is put or was already put.
+func (l *linker) plan(from abspath.AbsPath, to Destination) ([]Operation, bool, error) {
+	dst := to.Path.String()
+	link := Operation{
+		Kind:        OpLink,
+		Source:      from.String(),
+		Destination: dst,
+		Mapping:     to.Origin,
+		Mode:        to.Mode,
+		Relative:    to.Mode.isSymlink() && (l.opts.Relative || to.Relative),
+	}
+
+	if ok, reason := to.When.Check(); !ok {
+		link.Kind, link.Reason = OpSkip, reason
+		return []Operation{link}, false, nil
+	}
+
+	if src, ok := l.planned[dst]; ok {
+		// Note: Multiple keys may be mapped to the same destination. The first one is linked
+		link.Kind, link.Reason = OpSkip, fmt.Sprintf("destination is already linked from '%s'", src)
+		return []Operation{link}, false, nil
+	}
+
+	ops := []Operation{}
+	if to.Package != "" {
+		if op, ok := l.planUnfold(to.Path); ok {
+			ops = append(ops, op)
+		}
+	}
+
+	exists, outdated := false, false
+	if l.exists(dst) {
+		put, err := l.isPut(from, to)
+		if err != nil {
+			a, _, _, _ := link.action("")
+			output.failed(a, err)
+			return nil, false, err
+		}
+		if put {
+			// Already linked to the source in dotfiles repository
+			link.Kind = OpExists
+			l.planned[dst] = link.Source
+			return append(ops, link), true, nil
+		}
+		outdated = l.isOutdated(from, to)
+		exists = !outdated
+	}
+
+	if _, err := os.Stat(from.String()); err != nil {
+		// Note: Source can be put by adopting the existing file. It is only allowed for mappings in the
+		// repository since default mappings match many files in home directory
+		if !exists || l.opts.Conflict != ConflictAdopt || to.Origin == defaultMappingsOrigin {
+			return ops, false, nil
+		}
+	}
+
+	if exists {
+		op, ok, err := l.planConflict(from, to, link)
+		if err != nil {
+			return nil, false, err
+		}
+		ops = append(ops, op)
+		if !ok {
+			return ops, false, nil
+		}
+		if op.Kind == OpBackup {
+			link.Backup = op.Destination
+		}
+	}
+
+	if outdated {
+		// Note: Outdated copy put by this command is replaced
+		ops = append(ops, Operation{Kind: OpRemove, Destination: dst, Reason: "outdated"})
+	}
+
+	if dir := to.Path.Dir().String(); !l.exists(dir) {
+		ops = append(ops, l.mkdir(dir))
+	}
+
+	l.planned[dst] = link.Source
+	return append(ops, link), true, nil
+}
+
+// planLinks plans operations to put links of the files. It returns true as the second return value when
+// some link is put or was already put. Mappings in the repository are planned before default mappings so
+// that they take precedence when they share the same destination.
+func (maps Mappings) planLinks(files []string, l *linker) (*LinkPlan, bool, error) {
+	files = append([]string{}, files...)
+	sort.Strings(files)
+
+	type mapping struct {
+		file string
+		to   Destination
+	}
+	explicit, defaults := []mapping{}, []mapping{}
+	for _, f := range files {
+		for _, to := range maps[f] {
+			if to.Origin == defaultMappingsOrigin {
+				defaults = append(defaults, mapping{f, to})
+			} else {
+				explicit = append(explicit, mapping{f, to})
+			}
+		}
+	}
+
+	p := &LinkPlan{
+		Repository: l.repo.String(),
+		Home:       target.home,
+		Root:       target.root,
+		Operations: []Operation{},
+	}
+	linked := false
+	for _, m := range append(explicit, defaults...) {
+		ops, ok, err := l.plan(l.repo.Join(filepath.FromSlash(m.file)), m.to)
+		if err != nil {
+			return nil, false, err
+		}
+		p.Operations = append(p.Operations, ops...)
+		if ok {
+			linked = true
+		}
+	}
+
+	return p, linked, nil
+}
+
+func (l *linker) put(op Operation) error {
+	if !op.Mode.isGenerated() {
+		return op.Mode.put(op.Source, op.Destination, op.Relative)
+	}
+
+	if op.Mode == ModeDecrypt {
+		if isInside(op.Destination, l.repo.String()) {
+			return fmt.Errorf("decrypted file '%s' must not be written inside dotfiles repository '%s'", op.Destination, l.repo.String())
+		}
+		if err := ensureOutsideWorktree(op.Source, op.Destination); err != nil {
+			return err
+		}
+	}
+
+	b, perm, err := l.gen.generate(op.Source, op.Mode)
+	if err != nil {
+		return err
+	}
+	return writeGenerated(b, op.Destination, perm)
+}
+
+// record records the file put at the destination in manifest
+func (l *linker) record(op Operation) error {
+	e := ManifestEntry{
+		Source:      op.Source,
+		Destination: op.Destination,
+		Mapping:     op.Mapping,
+		Backup:      op.Backup,
+	}
+	if !op.Mode.isSymlink() {
+		c, err := checksumOf(e.Destination)
+		if err != nil {
+			return err
+		}
+		e.Mode = op.Mode
+		e.Checksum = c
+	}
+	l.manifest.Add(e)
+	return nil
+}
+
+// do applies the operation and journals the change on filesystem
+func (l *linker) do(op Operation) error {
+	e := journalEntry{op: op}
+	var err error
+
+	switch op.Kind {
+	case OpMkdir:
+		e.dirs = missingDirs(op.Destination)
+		if err = os.MkdirAll(op.Destination, os.ModeDir|os.ModePerm); err != nil {
+			// Note: Remove the directories made halfway since the change is not journaled
+			for i := len(e.dirs) - 1; i >= 0; i-- {
+				os.Remove(e.dirs[i])
+			}
+			return err
+		}
+	case OpUnfold:
+		if e.link, err = os.Readlink(op.Destination); err != nil {
+			return err
+		}
+		if err := os.Remove(op.Destination); err != nil {
+			return err
+		}
+		if err = os.Mkdir(op.Destination, 0755); err != nil {
+			// Note: Put the folded link back since the change is not journaled
+			os.Symlink(e.link, op.Destination)
+			return err
+		}
+		l.manifest.Remove(op.Destination)
+	case OpBackup:
+		err = moveFile(op.Source, op.Destination)
+	case OpRemove:
+		// Note: The file is actually removed when linking is committed
+		if e.aside, err = moveAside(op.Destination); err != nil {
+			return err
+		}
+		if e.aside == "" {
+			// Note: Nothing to restore since the file was already removed after planning
+			return nil
+		}
+	case OpAdopt:
+		// Note: The original source in the repository is replaced. It can be recovered with Git.
+		if e.aside, err = moveAside(op.Destination); err != nil {
+			return err
+		}
+		dir := filepath.Dir(op.Destination)
+		e.dirs = missingDirs(dir)
+		err = os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+		if err == nil {
+			err = moveFile(op.Source, op.Destination)
+		}
+		if err != nil {
+			// Note: Put the original source back since the change is not journaled
+			if e.aside != "" {
+				os.Rename(e.aside, op.Destination)
+			}
+			for i := len(e.dirs) - 1; i >= 0; i-- {
+				os.Remove(e.dirs[i])
+			}
+			return err
+		}
+	case OpLink:
+		_, statErr := os.Lstat(op.Destination)
+		if err := l.put(op); err != nil {
+			// Note: Remove the file partially copied or generated since the change is not journaled
+			if os.IsNotExist(statErr) {
+				os.RemoveAll(op.Destination)
+			}
+			return err
+		}
+		l.journal = append(l.journal, e)
+		return l.record(op)
+	case OpExists:
+		// Note: Record the link put before the manifest was introduced
+		return l.record(op)
+	case OpSkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown operation '%s' for '%s'", op.Kind, op.Destination)
+	}
+
+	if err != nil {
+		return err
+	}
+	l.journal = append(l.journal, e)
+	return nil
+}
+
+// apply applies the operations in the plan in order as a transaction. On the first failure or interruption,
+// all changes made so far are rolled back. With KeepGoing option, failures are reported at the end instead.
+// Nothing is changed on dry-run.
+func (l *linker) apply(p *LinkPlan) error {
+	if l.opts.Dry {
+		for _, op := range p.Operations {
+			op.report(ResultDryRun)
+		}
+		return nil
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	return l.transact(p, interrupted)
+}
+
+func isInterrupted(c <-chan os.Signal) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// transact applies the operations and commits the changes. An interruption is checked after each operation
+// so that the changes are rolled back until they are committed.
+func (l *linker) transact(p *LinkPlan, interrupted <-chan os.Signal) error {
+	failed := []error{}
+	for _, op := range p.Operations {
+		if err := l.do(op); err != nil {
+			a, _, _, _ := op.action("")
+			output.failed(a, err)
+			if !l.opts.KeepGoing {
+				return l.rollback(err)
+			}
+			failed = append(failed, fmt.Errorf("%s '%s': %s", op.Kind, op.Destination, err))
+		} else {
+			op.report(ResultDone)
+		}
+
+		if isInterrupted(interrupted) {
+			return l.rollback(errors.New("Linking was interrupted"))
+		}
+	}
+
+	// Note: Files moved aside are removed only after the manifest is saved. Otherwise they would be lost
+	// without any record of the links
+	if err := l.manifest.Save(); err != nil {
+		return l.rollback(err)
+	}
+	if err := l.commit(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &LinkFailedError{failed}
+	}
+	return nil
+}
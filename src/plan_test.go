@@ -0,0 +1,148 @@
+package dotfiles
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDryRunTouchesNothing(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+
+	repo, home := createTestRepo(map[string]string{"vimrc": "source"}, map[string]string{"vimrc": "a/b/.vimrc"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	if err := Link(repo.String(), nil, LinkOptions{Dry: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(home.Join("a").String()); err == nil {
+		t.Fatalf("Dry run must not create parent directory of destination")
+	}
+	f, err := manifestFile(repo)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := os.Stat(f.String()); err == nil {
+		t.Fatalf("Dry run must not write manifest")
+	}
+}
+
+func TestPlanThenApply(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+
+	repo, home := createTestRepo(map[string]string{"vimrc": "source"}, map[string]string{"vimrc": "a/.vimrc"})
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		panic(err)
+	}
+	m, err = m.expand(repo)
+	if err != nil {
+		panic(err)
+	}
+	l, err := newLinker(repo, LinkOptions{})
+	if err != nil {
+		panic(err)
+	}
+	p, linked, err := m.planLinks(m.filesToLink(nil), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !linked || len(p.Operations) != 2 || p.Operations[0].Kind != OpMkdir || p.Operations[1].Kind != OpLink {
+		t.Fatalf("Plan should make parent directory and put link: %+v", p.Operations)
+	}
+
+	if err := os.Mkdir(home.Join("a").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(home.Join("a", ".vimrc").String(), "existing")
+
+	out := home.Join("plan.json").String()
+	if err := Plan(repo.String(), nil, out, LinkOptions{Conflict: ConflictBackup}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(home.Join("a", ".vimrc").String()) != "existing" {
+		t.Fatalf("Planning must not touch destination")
+	}
+
+	saved, err := readPlanFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Repository != repo.String() || len(saved.Operations) != 2 || saved.Operations[0].Kind != OpBackup || saved.Operations[1].Kind != OpLink {
+		t.Fatalf("Plan file should back up existing file and put link: %+v", saved)
+	}
+
+	if err := Apply(out, false); err != nil {
+		t.Fatal(err)
+	}
+	dst := home.Join("a", ".vimrc").String()
+	if s, err := os.Lstat(dst); err != nil || s.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Link should be put by applying plan: %v", err)
+	}
+	if readFile(saved.Operations[0].Destination) != "existing" {
+		t.Fatalf("Existing file should be backed up")
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := manifest.find(dst)
+	if !ok || e.Backup != saved.Operations[0].Destination {
+		t.Fatalf("Applied link and its backup should be recorded in manifest: %+v", e)
+	}
+}
+
+func TestApplyBrokenPlanFile(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir.String())
+
+	for _, content := range []string{
+		`{"repository": "/path/to/repo", "steps": []}`,
+		`{"operations": []}`,
+		`{"repository": "/path/to/repo", "operations": [{"kind": "unknown", "destination": "/path/to/dst"}]}`,
+	} {
+		f := dir.Join("plan.json").String()
+		writeFile(f, content)
+		if err := Apply(f, false); err == nil {
+			t.Errorf("Broken plan file should cause an error: %s", content)
+		}
+	}
+}
+
+func TestPlanKeysMappedToSameDestination(t *testing.T) {
+	repo, home := createTestRepo(map[string]string{"_a.conf": "default", "_b.conf": "mine"}, nil)
+	defer os.RemoveAll(repo.String())
+	defer os.RemoveAll(home.String())
+
+	// Note: Mapping in the repository takes precedence over default mapping even if its key comes later
+	dst := home.Join(".x.conf")
+	m := Mappings{
+		"_a.conf": {Destination{Path: dst, Origin: defaultMappingsOrigin}},
+		"_b.conf": {Destination{Path: dst, Origin: "mappings.json"}},
+	}
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(dst.String()) != "mine" {
+		t.Fatalf("Mapping in the repository should be linked")
+	}
+
+	// Linking again reports the existing link and skips the other key
+	l, err := newLinker(repo, LinkOptions{})
+	if err != nil {
+		panic(err)
+	}
+	p, linked, err := m.planLinks(m.filesToLink(nil), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !linked || len(p.Operations) != 2 || p.Operations[0].Kind != OpExists || p.Operations[1].Kind != OpSkip {
+		t.Fatalf("Second key should be skipped: %+v", p.Operations)
+	}
+}
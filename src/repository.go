@@ -4,18 +4,26 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/rhysd/abspath"
 )
 
 // Repository represents a repository on local filesystem
-// TODO: Enable to specify branch name?
 type Repository struct {
 	URL             string
 	Path            abspath.AbsPath
 	IncludesRepoDir bool
 	Git             string
+	// Branch is a branch or tag name to check out after cloning. Default branch is used when empty
+	Branch string
+	// Depth is a depth of shallow clone. Full history is cloned when zero
+	Depth int
+	// RecurseSubmodules initializes submodules after cloning
+	RecurseSubmodules bool
+	// Origin is a name of the remote. "origin" is used when empty
+	Origin string
 }
 
 func pathToCloneRepo(specified string) (abspath.AbsPath, bool, error) {
@@ -48,45 +56,133 @@ func pathToCloneRepo(specified string) (abspath.AbsPath, bool, error) {
 	return repo, false, nil
 }
 
-func NewRepository(spec, specified string, https bool) (*Repository, error) {
-	if spec == "" {
-		return nil, fmt.Errorf("remote path to clone must not be empty")
+// forgeHosts is a map from shorthand prefixes of repository to host names (e.g. gitlab:user/repo)
+var forgeHosts = map[string]string{
+	"github":    "github.com",
+	"gitlab":    "gitlab.com",
+	"bitbucket": "bitbucket.org",
+	"codeberg":  "codeberg.org",
+}
+
+// defaultGitHost returns a host name used when a repository is specified with only user name or repository
+// name. $DOTFILES_GIT_HOST takes precedence over `git_host` in user config file.
+func defaultGitHost() (string, error) {
+	if env := os.Getenv("DOTFILES_GIT_HOST"); env != "" {
+		return strings.TrimSuffix(env, "/"), nil
+	}
+	c, err := readUserConfig()
+	if err != nil {
+		return "", err
 	}
+	if c.GitHost != "" {
+		return strings.TrimSuffix(c.GitHost, "/"), nil
+	}
+	return "github.com", nil
+}
 
-	if strings.HasPrefix(spec, "https://") {
-		if !strings.HasSuffix(spec, ".git") {
-			spec = spec + ".git"
-		}
-	} else if strings.HasPrefix(spec, "git@") {
+func remoteURL(spec string, https bool) (string, error) {
+	if strings.HasPrefix(spec, "https://") || strings.HasPrefix(spec, "git@") {
 		if !strings.HasSuffix(spec, ".git") {
 			spec = spec + ".git"
 		}
-	} else if strings.ContainsRune(spec, '/') {
-		if https {
-			spec = fmt.Sprintf("https://github.com/%s.git", spec)
-		} else {
-			spec = fmt.Sprintf("git@github.com:%s.git", spec)
+		return spec, nil
+	}
+
+	// Note: Other URLs such as ssh:// and file:// are used as-is since their paths may not end with .git
+	if strings.Contains(spec, "://") {
+		return spec, nil
+	}
+
+	host := ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		h, ok := forgeHosts[spec[:i]]
+		if !ok {
+			return "", fmt.Errorf("unknown host '%s' in repository '%s'. Available hosts are github, gitlab, bitbucket and codeberg", spec[:i], spec)
 		}
+		host, spec = h, spec[i+1:]
 	} else {
-		if https {
-			spec = fmt.Sprintf("https://github.com/%s/dotfiles.git", spec)
-		} else {
-			spec = fmt.Sprintf("git@github.com:%s/dotfiles.git", spec)
+		h, err := defaultGitHost()
+		if err != nil {
+			return "", err
 		}
+		host = h
+	}
+
+	if spec == "" || strings.HasPrefix(spec, "/") || strings.HasSuffix(spec, "/") {
+		return "", fmt.Errorf("repository must be 'user' or 'user/repo-name' but got '%s'", spec)
+	}
+	if !strings.ContainsRune(spec, '/') {
+		spec = spec + "/dotfiles"
+	}
+
+	if https {
+		return fmt.Sprintf("https://%s/%s.git", host, spec), nil
+	}
+	return fmt.Sprintf("git@%s:%s.git", host, spec), nil
+}
+
+func NewRepository(spec, specified string, https bool) (*Repository, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("remote path to clone must not be empty")
+	}
+
+	spec, err := remoteURL(spec, https)
+	if err != nil {
+		return nil, err
 	}
 
 	p, b, err := pathToCloneRepo(specified)
 	if err != nil {
 		return nil, err
 	}
-	return &Repository{spec, p, b, os.Getenv("DOTFILES_GIT_COMMAND")}, nil
+	return &Repository{URL: spec, Path: p, IncludesRepoDir: b, Git: os.Getenv("DOTFILES_GIT_COMMAND")}, nil
 }
 
-func (repo *Repository) Clone() error {
-	args := []string{"clone", repo.URL}
+// clonedDir returns the directory of the cloned repository. When the path does not include the repository
+// directory, the directory name is derived from the URL in the same way as `git clone`.
+func (repo *Repository) clonedDir() abspath.AbsPath {
+	if repo.IncludesRepoDir {
+		return repo.Path
+	}
+	name := strings.TrimSuffix(repo.URL, ".git")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	return repo.Path.Join(name)
+}
+
+// gitExecutable returns the git command. "git" is used when it is not specified
+func gitExecutable(specified string) string {
+	if specified == "" {
+		return "git"
+	}
+	return specified
+}
+
+func (repo *Repository) cloneArgs() []string {
+	args := []string{"clone"}
+	if repo.Branch != "" {
+		args = append(args, "--branch", repo.Branch)
+	}
+	if repo.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(repo.Depth))
+	}
+	if repo.RecurseSubmodules {
+		args = append(args, "--recurse-submodules")
+	}
+	if repo.Origin != "" {
+		args = append(args, "--origin", repo.Origin)
+	}
+	args = append(args, repo.URL)
 	if repo.IncludesRepoDir {
 		args = append(args, repo.Path.String())
-	} else {
+	}
+	return args
+}
+
+func (repo *Repository) Clone() error {
+	args := repo.cloneArgs()
+	if !repo.IncludesRepoDir {
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
@@ -99,12 +195,7 @@ func (repo *Repository) Clone() error {
 		}
 	}
 
-	exe := repo.Git
-	if exe == "" {
-		exe = "git"
-	}
-
-	cmd := exec.Command(exe, args...)
+	cmd := exec.Command(gitExecutable(repo.Git), args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
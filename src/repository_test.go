@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/rhysd/abspath"
 )
 
 func getwd() string {
@@ -66,6 +68,12 @@ func TestNewRepositoryNormalizeRepoUrl(t *testing.T) {
 		"rhysd/foobar":                          "git@github.com:rhysd/foobar.git",
 		"https://github.com/rhysd/dogfiles.git": "https://github.com/rhysd/dogfiles.git",
 		"https://github.com/rhysd/dogfiles":     "https://github.com/rhysd/dogfiles.git",
+		"gitlab:rhysd/foobar":                   "git@gitlab.com:rhysd/foobar.git",
+		"bitbucket:rhysd":                       "git@bitbucket.org:rhysd/dotfiles.git",
+		"codeberg:rhysd":                        "git@codeberg.org:rhysd/dotfiles.git",
+		"github:rhysd":                          "git@github.com:rhysd/dotfiles.git",
+		"ssh://git@example.com:2222/rhysd/dots": "ssh://git@example.com:2222/rhysd/dots",
+		"file:///path/to/dotfiles":              "file:///path/to/dotfiles",
 	}
 
 	for input, expected := range successCases {
@@ -84,6 +92,8 @@ func TestNewRepositoryWithHttps(t *testing.T) {
 		"rhysd":                                 "https://github.com/rhysd/dotfiles.git",
 		"rhysd/foobar":                          "https://github.com/rhysd/foobar.git",
 		"https://github.com/rhysd/dogfiles.git": "https://github.com/rhysd/dogfiles.git",
+		"gitlab:rhysd/foobar":                   "https://gitlab.com/rhysd/foobar.git",
+		"codeberg:rhysd":                        "https://codeberg.org/rhysd/dotfiles.git",
 	}
 
 	for input, expected := range successCases {
@@ -220,3 +230,87 @@ func TestCloneWithEnv(t *testing.T) {
 		t.Fatalf("Cloned repository must be a directory: '%s'", repo)
 	}
 }
+
+func TestClonedDir(t *testing.T) {
+	p, err := abspath.ExpandFrom("/path/to")
+	if err != nil {
+		panic(err)
+	}
+	for url, want := range map[string]string{
+		"git@github.com:rhysd/dotfiles.git":     "/path/to/dotfiles",
+		"https://github.com/rhysd/dogfiles.git": "/path/to/dogfiles",
+		"git@example.com:dotfiles.git":          "/path/to/dotfiles",
+	} {
+		r := &Repository{URL: url, Path: p}
+		if have := r.clonedDir().ToSlash(); have != want {
+			t.Errorf("Directory cloned from %s should be %s but got %s", url, want, have)
+		}
+	}
+}
+
+func TestCloneArgs(t *testing.T) {
+	p, err := abspath.ExpandFrom("/path/to/dotfiles")
+	if err != nil {
+		panic(err)
+	}
+	r := &Repository{
+		URL:               "https://github.com/rhysd/dotfiles.git",
+		Path:              p,
+		IncludesRepoDir:   true,
+		Branch:            "v1.0.0",
+		Depth:             1,
+		RecurseSubmodules: true,
+		Origin:            "upstream",
+	}
+	want := []string{
+		"clone", "--branch", "v1.0.0", "--depth", "1", "--recurse-submodules", "--origin", "upstream",
+		"https://github.com/rhysd/dotfiles.git", p.String(),
+	}
+	if have := r.cloneArgs(); strings.Join(have, " ") != strings.Join(want, " ") {
+		t.Errorf("Wanted %v but got %v", want, have)
+	}
+
+	r = &Repository{URL: "git@github.com:rhysd/dotfiles.git", Path: p}
+	if have := r.cloneArgs(); strings.Join(have, " ") != "clone git@github.com:rhysd/dotfiles.git" {
+		t.Errorf("Only URL should be passed by default but got %v", have)
+	}
+}
+
+func TestNewRepositoryInvalidHost(t *testing.T) {
+	for _, spec := range []string{"unknown:rhysd", "gitlab:", "gitlab:rhysd/"} {
+		if _, err := NewRepository(spec, "", false); err == nil {
+			t.Errorf("Repository '%s' should cause an error", spec)
+		}
+	}
+}
+
+func TestNewRepositoryDefaultHost(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir.String())
+	saved := os.Getenv("XDG_CONFIG_HOME")
+	defer os.Setenv("XDG_CONFIG_HOME", saved)
+	os.Setenv("XDG_CONFIG_HOME", dir.String())
+
+	if err := os.Mkdir(dir.Join("dotfiles").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(dir.Join("dotfiles", "config.toml").String(), `git_host = "gitlab.example.com"`)
+
+	r, err := NewRepository("rhysd", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.URL != "https://gitlab.example.com/rhysd/dotfiles.git" {
+		t.Errorf("Host in user config should be used: %s", r.URL)
+	}
+
+	os.Setenv("DOTFILES_GIT_HOST", "git.example.com")
+	defer os.Unsetenv("DOTFILES_GIT_HOST")
+	r, err = NewRepository("rhysd/foo", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.URL != "git@git.example.com:rhysd/foo.git" {
+		t.Errorf("$DOTFILES_GIT_HOST should be used: %s", r.URL)
+	}
+}
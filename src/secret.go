@@ -0,0 +1,248 @@
+package dotfiles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/rhysd/abspath"
+)
+
+// encryptedExt is an extension of source files which are decrypted by default. The extension is removed
+// from destinations in directories.
+const encryptedExt = ".age"
+
+// secretPerm is a permission of decrypted files
+const secretPerm = 0600
+
+// keyFile returns the path of age identity file. $DOTFILES_AGE_KEY_FILE takes precedence.
+func keyFile() (abspath.AbsPath, error) {
+	if env := os.Getenv("DOTFILES_AGE_KEY_FILE"); env != "" {
+		return abspath.ExpandFrom(env)
+	}
+	d, err := userConfigDir()
+	if err != nil {
+		return abspath.AbsPath{}, err
+	}
+	return d.Join("key.txt"), nil
+}
+
+func readIdentities() ([]age.Identity, error) {
+	p, err := keyFile()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p.String())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read age key file. Please generate it with `age-keygen -o %s` or set $DOTFILES_AGE_KEY_FILE: %s", p.String(), err)
+	}
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age key file '%s': %s", p.String(), err)
+	}
+
+	return ids, nil
+}
+
+// recipientsFor returns recipients to encrypt files. Files are always encrypted for identities in the key
+// file so that they can be decrypted on this machine.
+func recipientsFor(extra []string) ([]age.Recipient, error) {
+	ids, err := readIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := []age.Recipient{}
+	for _, id := range ids {
+		if x, ok := id.(*age.X25519Identity); ok {
+			rs = append(rs, x.Recipient())
+		}
+	}
+
+	for _, s := range extra {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+
+	if len(rs) == 0 {
+		return nil, fmt.Errorf("no X25519 identity was found in age key file")
+	}
+
+	return rs, nil
+}
+
+func encrypt(plain []byte, recipients []age.Recipient, armored bool) ([]byte, error) {
+	var out bytes.Buffer
+	var w io.WriteCloser = nopWriteCloser{&out}
+	if armored {
+		w = armor.NewWriter(&out)
+	}
+
+	e, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.Write(plain); err != nil {
+		return nil, err
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func decrypt(encrypted []byte, ids []age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(encrypted)
+	if bytes.HasPrefix(bytes.TrimSpace(encrypted), []byte(armor.Header)) {
+		r = armor.NewReader(r)
+	}
+
+	d, err := age.Decrypt(r, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(d)
+}
+
+// decrypter decrypts source files. Identities are loaded lazily since they are not necessary when no
+// encrypted file is used.
+type decrypter struct {
+	ids []age.Identity
+}
+
+func (d *decrypter) decrypt(src string) ([]byte, error) {
+	if d.ids == nil {
+		ids, err := readIdentities()
+		if err != nil {
+			return nil, err
+		}
+		d.ids = ids
+	}
+
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := decrypt(b, d.ids)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt '%s': %s", src, err)
+	}
+
+	return plain, nil
+}
+
+// worktreeOf returns the root of Git worktree which contains the path
+func worktreeOf(path string) (string, bool) {
+	for d := filepath.Dir(path); d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+func isInside(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// ensureOutsideWorktree returns an error when a decrypted file would be written into the worktree which
+// contains the encrypted file. It prevents committing secrets accidentally.
+func ensureOutsideWorktree(encrypted, dst string) error {
+	if w, ok := worktreeOf(encrypted); ok && isInside(dst, w) {
+		return fmt.Errorf("decrypted file '%s' must not be written inside repository '%s'", dst, w)
+	}
+	return nil
+}
+
+// Encrypt encrypts the file with age. The output is written to '{file}.age' when output is empty.
+func Encrypt(file, output string, recipients []string, armored bool) error {
+	src, err := abspath.ExpandFrom(file)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = src.String() + encryptedExt
+	}
+
+	rs, err := recipientsFor(recipients)
+	if err != nil {
+		return err
+	}
+
+	plain, err := ioutil.ReadFile(src.String())
+	if err != nil {
+		return err
+	}
+
+	b, err := encrypt(plain, rs, armored)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(output, b, 0644); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Encrypted '%s' into '%s'\n", src.String(), output)
+	return nil
+}
+
+// Decrypt decrypts the file encrypted with age. The output is written to stdout when output is empty.
+// Decrypted file cannot be written inside the worktree of the encrypted file.
+func Decrypt(file, output string) error {
+	src, err := abspath.ExpandFrom(file)
+	if err != nil {
+		return err
+	}
+
+	d := &decrypter{}
+	plain, err := d.decrypt(src.String())
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		w := bufio.NewWriter(os.Stdout)
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	dst, err := abspath.ExpandFrom(output)
+	if err != nil {
+		return err
+	}
+	if err := ensureOutsideWorktree(src.String(), dst.String()); err != nil {
+		return err
+	}
+
+	return writeGenerated(plain, dst.String(), secretPerm)
+}
@@ -0,0 +1,200 @@
+package dotfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// setupAgeKey creates a temporary age key file and sets $DOTFILES_AGE_KEY_FILE
+func setupAgeKey() (*age.X25519Identity, func()) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		panic(err)
+	}
+	dir, err := ioutil.TempDir("", "dotfiles-age-")
+	if err != nil {
+		panic(err)
+	}
+	key := filepath.Join(dir, "key.txt")
+	writeFile(key, "# test key\n"+id.String()+"\n")
+	os.Setenv("DOTFILES_AGE_KEY_FILE", key)
+	return id, func() {
+		os.Unsetenv("DOTFILES_AGE_KEY_FILE")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, armored := range []bool{false, true} {
+		b, err := encrypt([]byte("secret"), []age.Recipient{id.Recipient()}, armored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := decrypt(b, []age.Identity{id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plain) != "secret" {
+			t.Errorf("Decrypted content is unexpected (armored: %v): %q", armored, plain)
+		}
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		panic(err)
+	}
+	b, err := encrypt([]byte("secret"), []age.Recipient{id.Recipient()}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decrypt(b, []age.Identity{other}); err == nil {
+		t.Errorf("File should not be decrypted with other identity")
+	}
+}
+
+func TestKeyFileFromEnv(t *testing.T) {
+	os.Setenv("DOTFILES_AGE_KEY_FILE", "/path/to/key.txt")
+	defer os.Unsetenv("DOTFILES_AGE_KEY_FILE")
+
+	p, err := keyFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "/path/to/key.txt" {
+		t.Errorf("$DOTFILES_AGE_KEY_FILE should be used: %s", p.String())
+	}
+}
+
+func TestEncryptAndDecryptCommands(t *testing.T) {
+	_, cleanup := setupAgeKey()
+	defer cleanup()
+	dir := tempDir()
+	defer os.RemoveAll(dir.String())
+
+	plain := dir.Join("netrc").String()
+	writeFile(plain, "machine example.com password foo")
+
+	if err := Encrypt(plain, "", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := plain + encryptedExt
+	if readFile(encrypted) == readFile(plain) {
+		t.Fatalf("File should be encrypted")
+	}
+
+	out := dir.Join("decrypted").String()
+	if err := Decrypt(encrypted, out); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(out) != "machine example.com password foo" {
+		t.Fatalf("File should be decrypted: %q", readFile(out))
+	}
+	if s, err := os.Stat(out); err != nil || s.Mode().Perm() != secretPerm {
+		t.Fatalf("Decrypted file should have 0600 permission: %v", s.Mode())
+	}
+
+	// Decrypted file must not be written inside repository
+	if err := os.Mkdir(dir.Join(".git").String(), 0755); err != nil {
+		panic(err)
+	}
+	if err := Decrypt(encrypted, dir.Join("leaked").String()); err == nil {
+		t.Fatalf("Decrypting into repository should cause an error")
+	}
+	if _, err := os.Stat(dir.Join("leaked").String()); err == nil {
+		t.Fatalf("Decrypted file should not be written")
+	}
+}
+
+func TestLinkEncryptedFile(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	id, cleanup := setupAgeKey()
+	defer cleanup()
+
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+	home := tempDir()
+	defer os.RemoveAll(home.String())
+
+	b, err := encrypt([]byte("token"), []age.Recipient{id.Recipient()}, false)
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(repo.Join("npmrc.age").String(), b, 0644); err != nil {
+		panic(err)
+	}
+
+	m := Mappings{
+		"npmrc.age": []Destination{{Path: home.Join(".npmrc"), Origin: "mappings.json"}},
+	}
+	m, err = m.expand(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["npmrc.age"][0].Mode != ModeDecrypt {
+		t.Fatalf("Encrypted file should be decrypted by default: %+v", m["npmrc.age"])
+	}
+
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dst := home.Join(".npmrc").String()
+	if readFile(dst) != "token" {
+		t.Fatalf("File should be decrypted: %q", readFile(dst))
+	}
+	if s, err := os.Stat(dst); err != nil || s.Mode().Perm() != secretPerm {
+		t.Fatalf("Decrypted file should have 0600 permission: %v", s.Mode())
+	}
+
+	sts, err := m.Status(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusLinked {
+		t.Fatalf("Decrypted file should be linked: %+v", sts)
+	}
+
+	if err := m.UnlinkAll(repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		t.Fatalf("Decrypted file should be removed by clean")
+	}
+}
+
+func TestLinkEncryptedFileIntoRepository(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	id, cleanup := setupAgeKey()
+	defer cleanup()
+
+	repo := tempDir()
+	defer os.RemoveAll(repo.String())
+
+	b, err := encrypt([]byte("token"), []age.Recipient{id.Recipient()}, false)
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(repo.Join("npmrc.age").String(), b, 0644); err != nil {
+		panic(err)
+	}
+
+	m := Mappings{
+		"npmrc.age": []Destination{{Path: repo.Join("npmrc"), Origin: "mappings.json", Mode: ModeDecrypt}},
+	}
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err == nil {
+		t.Fatalf("Decrypting into repository should cause an error")
+	}
+	if _, err := os.Lstat(repo.Join("npmrc").String()); err == nil {
+		t.Fatalf("Decrypted file should not be written into repository")
+	}
+}
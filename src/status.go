@@ -0,0 +1,168 @@
+package dotfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rhysd/abspath"
+)
+
+// LinkStatus represents the state of one destination of mappings
+type LinkStatus string
+
+const (
+	// StatusLinked means the destination is a symlink to the source in dotfiles repository
+	StatusLinked LinkStatus = "linked"
+	// StatusMissing means nothing exists at the destination
+	StatusMissing LinkStatus = "missing"
+	// StatusBlocked means a file which is not a symlink exists at the destination
+	StatusBlocked LinkStatus = "blocked"
+	// StatusOther means the destination is a symlink to some other file such as a file in different repository
+	StatusOther LinkStatus = "other"
+	// StatusBroken means the destination is a symlink to a file which does not exist
+	StatusBroken LinkStatus = "broken"
+	// StatusSourceMissing means the source file does not exist in dotfiles repository
+	StatusSourceMissing LinkStatus = "source-missing"
+	// StatusSkipped means the condition of the mapping is not satisfied
+	StatusSkipped LinkStatus = "skipped"
+	// StatusOutdated means the file copied, hard-linked or rendered by this command is not modified but the
+	// source or template variables were updated
+	StatusOutdated LinkStatus = "outdated"
+)
+
+// MappingStatus is a status of one mapping from a source file to its destination
+type MappingStatus struct {
+	Source      string
+	Destination string
+	Origin      string
+	Status      LinkStatus
+	// Target is a path the symlink at destination actually points to. It is empty when it is not a symlink.
+	Target string
+	// Reason is a reason why the mapping is skipped
+	Reason string
+}
+
+// OutOfSyncError is returned when some mappings are not linked correctly
+type OutOfSyncError struct {
+	Count int
+}
+
+func (err *OutOfSyncError) Error() string {
+	return fmt.Sprintf("%d mapping(s) are out of sync. Please check the output of status", err.Count)
+}
+
+func statusOf(from abspath.AbsPath, to Destination, manifest *Manifest, gen *generator) (MappingStatus, error) {
+	st := MappingStatus{
+		Source:      from.String(),
+		Destination: to.Path.String(),
+		Origin:      to.Origin,
+	}
+
+	if s, err := os.Lstat(st.Destination); err == nil && s.Mode()&os.ModeSymlink != 0 {
+		if t, err := os.Readlink(st.Destination); err == nil {
+			st.Target = t
+		}
+	}
+
+	if ok, reason := to.When.Check(); !ok {
+		st.Status = StatusSkipped
+		st.Reason = reason
+		return st, nil
+	}
+
+	if _, err := os.Stat(st.Source); err != nil {
+		st.Status = StatusSourceMissing
+		return st, nil
+	}
+
+	s, err := os.Lstat(st.Destination)
+	if err != nil {
+		st.Status = StatusMissing
+		return st, nil
+	}
+
+	if !to.Mode.isSymlink() {
+		put := false
+		if to.Mode.isGenerated() {
+			b, _, err := gen.generate(st.Source, to.Mode)
+			if err != nil {
+				return st, err
+			}
+			put = hasContent(b, st.Destination)
+		} else {
+			put = to.Mode.isPut(st.Source, st.Destination)
+		}
+		if put {
+			st.Status = StatusLinked
+		} else if e, ok := manifest.find(st.Destination); ok && e.Source == st.Source && e.isAlive() {
+			st.Status = StatusOutdated
+		} else {
+			st.Status = StatusBlocked
+		}
+		return st, nil
+	}
+
+	if s.Mode()&os.ModeSymlink == 0 {
+		st.Status = StatusBlocked
+		return st, nil
+	}
+
+	t, err := readLink(st.Destination)
+	if err != nil {
+		return st, err
+	}
+
+	if t == st.Source {
+		st.Status = StatusLinked
+		return st, nil
+	}
+
+	if _, err := os.Stat(t); err != nil {
+		st.Status = StatusBroken
+		return st, nil
+	}
+
+	st.Status = StatusOther
+	return st, nil
+}
+
+// Status returns statuses of all mappings. Default mappings whose source does not exist in dotfiles
+// repository are omitted since they are not used.
+func (maps Mappings) Status(repo abspath.AbsPath) ([]MappingStatus, error) {
+	maps, err := maps.expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := LoadManifest(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	gen := newGenerator(repo)
+	ret := []MappingStatus{}
+	for f, tos := range maps {
+		from := repo.Join(filepath.FromSlash(f))
+		for _, to := range tos {
+			st, err := statusOf(from, to, manifest, gen)
+			if err != nil {
+				return nil, err
+			}
+			if st.Status == StatusSourceMissing && to.Origin == defaultMappingsOrigin {
+				continue
+			}
+			ret = append(ret, st)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Source != ret[j].Source {
+			return ret[i].Source < ret[j].Source
+		}
+		return ret[i].Destination < ret[j].Destination
+	})
+
+	return ret, nil
+}
@@ -0,0 +1,85 @@
+package dotfiles
+
+import (
+	"os"
+	"testing"
+)
+
+func statusFor(sts []MappingStatus, dst string) LinkStatus {
+	for _, s := range sts {
+		if s.Destination == getcwd().Join(dst).String() {
+			return s.Status
+		}
+	}
+	return ""
+}
+
+func TestStatusClassification(t *testing.T) {
+	openFile("._source.conf").Close()
+	defer os.Remove("._source.conf")
+	openFile("._other.conf").Close()
+	defer os.Remove("._other.conf")
+
+	createSymlink("._source.conf", "._linked.conf")
+	defer os.Remove("._linked.conf")
+	openFile("._blocked.conf").Close()
+	defer os.Remove("._blocked.conf")
+	createSymlink("._other.conf", "._other_link.conf")
+	defer os.Remove("._other_link.conf")
+	createSymlink("._not_exist.conf", "._broken.conf")
+	defer os.Remove("._broken.conf")
+
+	cwd := getcwd()
+	m := Mappings{
+		"._source.conf": []Destination{
+			{Path: cwd.Join("._linked.conf"), Origin: "mappings.json"},
+			{Path: cwd.Join("._missing.conf"), Origin: "mappings.json"},
+			{Path: cwd.Join("._blocked.conf"), Origin: "mappings.json"},
+			{Path: cwd.Join("._other_link.conf"), Origin: "mappings.json"},
+			{Path: cwd.Join("._broken.conf"), Origin: "mappings.json"},
+		},
+		"._unknown.conf":         []Destination{{Path: cwd.Join("._source_missing.conf"), Origin: "mappings.json"}},
+		"._unknown_default.conf": []Destination{{Path: cwd.Join("._default.conf"), Origin: defaultMappingsOrigin}},
+	}
+
+	sts, err := m.Status(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]LinkStatus{
+		"._linked.conf":         StatusLinked,
+		"._missing.conf":        StatusMissing,
+		"._blocked.conf":        StatusBlocked,
+		"._other_link.conf":     StatusOther,
+		"._broken.conf":         StatusBroken,
+		"._source_missing.conf": StatusSourceMissing,
+	}
+	for dst, want := range expected {
+		if have := statusFor(sts, dst); have != want {
+			t.Errorf("Wanted status '%s' for %s but got '%s'", want, dst, have)
+		}
+	}
+
+	if len(sts) != len(expected) {
+		t.Errorf("Default mapping whose source does not exist should be omitted: %v", sts)
+	}
+}
+
+func TestStatusConditionNotSatisfied(t *testing.T) {
+	openFile("._source.conf").Close()
+	defer os.Remove("._source.conf")
+
+	cwd := getcwd()
+	m := Mappings{
+		"._source.conf": []Destination{{Path: cwd.Join("._skipped.conf"), Origin: "mappings.json", When: &Condition{OS: "unknown"}}},
+	}
+
+	sts, err := m.Status(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusSkipped || sts[0].Reason == "" {
+		t.Fatalf("Mapping should be skipped with reason: %+v", sts)
+	}
+}
@@ -0,0 +1,90 @@
+package dotfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rhysd/abspath"
+)
+
+// targetDirs is directories where destinations are put instead of the actual home directory and the root
+// directory. They are used for deploying dotfiles into a staging tree such as a container image.
+type targetDirs struct {
+	// home is a directory which '~' and $HOME in destinations are expanded to
+	home string
+	// root is a directory prefixed to all destinations. Symbolic links put under it point to paths seen
+	// from it
+	root string
+}
+
+var target targetDirs
+
+// SetTarget sets the home directory and the root directory where destinations are put. Empty string means
+// the actual home directory or '/'. The root directory must exist.
+func SetTarget(home, root string) error {
+	t := targetDirs{}
+	if home != "" {
+		p, err := abspath.ExpandFrom(home)
+		if err != nil {
+			return err
+		}
+		t.home = p.String()
+	}
+	if root != "" {
+		p, err := abspath.ExpandFrom(root)
+		if err != nil {
+			return err
+		}
+		if s, err := os.Stat(p.String()); err != nil || !s.IsDir() {
+			return fmt.Errorf("root directory '%s' does not exist", p.String())
+		}
+		t.root = p.String()
+	}
+	target = t
+	return nil
+}
+
+// expandDestination expands the destination written in slash-separated path
+func expandDestination(dst string) (abspath.AbsPath, error) {
+	if target.home != "" && (dst == "~" || strings.HasPrefix(dst, "~/")) {
+		dst = filepath.ToSlash(target.home) + dst[1:]
+	}
+
+	p, err := abspath.ExpandFromSlash(dst)
+	if err != nil {
+		return abspath.AbsPath{}, err
+	}
+	if target.root == "" {
+		return p, nil
+	}
+
+	s := p.String()
+	return abspath.New(filepath.Join(target.root, s[len(filepath.VolumeName(s)):]))
+}
+
+// inRoot returns the path seen from the root directory. A path outside the root directory is returned
+// as-is.
+func inRoot(p string) string {
+	if target.root == "" || !isInside(p, target.root) {
+		return p
+	}
+	if p == target.root {
+		return string(filepath.Separator)
+	}
+	return p[len(target.root):]
+}
+
+// fromRoot returns the actual path of the path seen from the root directory. A path which does not exist
+// under the root directory is returned as-is since it may point outside the root directory.
+func fromRoot(p string) string {
+	if target.root == "" || isInside(p, target.root) {
+		return p
+	}
+	a := filepath.Join(target.root, p[len(filepath.VolumeName(p)):])
+	if _, err := os.Lstat(a); err != nil {
+		return p
+	}
+	return a
+}
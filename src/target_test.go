@@ -0,0 +1,113 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandDestinationWithTarget(t *testing.T) {
+	root := tempDir()
+	defer os.RemoveAll(root.String())
+	if err := SetTarget("/home/foo", root.String()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTarget("", "")
+
+	for dst, want := range map[string]string{
+		"~/.vimrc":      root.Join("home", "foo", ".vimrc").String(),
+		"/etc/foo.conf": root.Join("etc", "foo.conf").String(),
+	} {
+		p, err := expandDestination(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != want {
+			t.Errorf("'%s' should be expanded to '%s' but got '%s'", dst, want, p.String())
+		}
+	}
+
+	for dst, want := range map[string]string{
+		"$HOME/.zshrc":     "/home/foo/.zshrc",
+		"$XDG_DATA_HOME/x": "~/.local/share/x",
+	} {
+		have, err := expandEnv(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != want {
+			t.Errorf("'%s' should be expanded to '%s' with alternate home but got '%s'", dst, want, have)
+		}
+	}
+
+	if inRoot(root.Join("opt", "dotfiles").String()) != filepath.FromSlash("/opt/dotfiles") {
+		t.Errorf("Path seen from root is wrong: %s", inRoot(root.Join("opt", "dotfiles").String()))
+	}
+	if inRoot("/outside/root") != "/outside/root" {
+		t.Errorf("Path outside root should not be changed")
+	}
+
+	if err := SetTarget("", root.Join("not_exist").String()); err == nil {
+		t.Errorf("Root directory which does not exist should cause an error")
+	}
+}
+
+func TestLinkIntoRootDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("paths in this test are Unix paths")
+	}
+	root := tempDir()
+	defer os.RemoveAll(root.String())
+	if err := SetTarget("/home/foo", root.String()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTarget("", "")
+	resetManifest()
+	defer resetManifest()
+
+	repo := root.Join("opt", "dotfiles")
+	if err := os.MkdirAll(repo.Join(".dotfiles").String(), 0755); err != nil {
+		panic(err)
+	}
+	writeFile(repo.Join("_a.conf").String(), "")
+	writeFile(repo.Join(".dotfiles", "mappings.json").String(), `{"_a.conf": "~/.a.conf"}`)
+
+	m, err := GetMappingsForPlatform("unknown", repo.Join(".dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, relative := range []bool{false, true} {
+		if err := m.CreateAllLinks(repo, LinkOptions{Relative: relative}); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := root.Join("home", "foo", ".a.conf").String()
+		raw, err := os.Readlink(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "/opt/dotfiles/_a.conf"
+		if relative {
+			want = "../../opt/dotfiles/_a.conf"
+		}
+		if raw != want {
+			t.Fatalf("Link should point to path seen from root '%s' but got '%s'", want, raw)
+		}
+
+		sts, err := m.Status(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sts) != 1 || sts[0].Status != StatusLinked {
+			t.Fatalf("Link into root directory should be linked: %+v", sts)
+		}
+
+		if err := m.UnlinkAll(repo); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			t.Fatalf("Link into root directory should be removed")
+		}
+	}
+}
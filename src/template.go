@@ -0,0 +1,107 @@
+package dotfiles
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/rhysd/abspath"
+)
+
+// templateExt is an extension of source files which are rendered as templates by default. The extension
+// is removed from destinations in directories.
+const templateExt = ".tmpl"
+
+// TemplateData is data passed to templates on rendering
+type TemplateData struct {
+	// Vars is variables defined in .dotfiles/vars.json and its host, user and profile specific variants
+	Vars     map[string]interface{}
+	Env      map[string]string
+	OS       string
+	Arch     string
+	Hostname string
+	User     string
+	Home     string
+	Profile  string
+}
+
+func loadTemplateData(repo abspath.AbsPath, platform string) (*TemplateData, error) {
+	d := &TemplateData{
+		Vars:    map[string]interface{}{},
+		Env:     map[string]string{},
+		OS:      platform,
+		Arch:    runtime.GOARCH,
+		User:    username(),
+		Profile: os.Getenv("DOTFILES_PROFILE"),
+	}
+
+	if h, err := os.Hostname(); err == nil {
+		d.Hostname = h
+	}
+	if target.home != "" {
+		// Note: Templates are rendered for the alternate home directory
+		d.Home = target.home
+	} else if h, err := abspath.ExpandFromSlash("~"); err == nil {
+		d.Home = h.String()
+	}
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			d.Env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	dir := repo.Join(".dotfiles")
+	for _, name := range layeredFileNames("vars", platform) {
+		file, ok := findMappingsFile(dir, name)
+		if !ok {
+			continue
+		}
+		vars, _, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			d.Vars[k] = v
+		}
+	}
+
+	return d, nil
+}
+
+// templateRenderer renders source files as templates. Template data is loaded lazily since it is not
+// necessary when no template is used.
+type templateRenderer struct {
+	repo abspath.AbsPath
+	data *TemplateData
+}
+
+func (r *templateRenderer) render(src string) ([]byte, error) {
+	if r.data == nil {
+		d, err := loadTemplateData(r.repo, runtime.GOOS)
+		if err != nil {
+			return nil, err
+		}
+		r.data = d
+	}
+
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := template.New(filepath.Base(src)).Option("missingkey=error").Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, r.data); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package dotfiles
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testTemplateRepo = "_test_template_repo"
+
+func createTemplateRepo(vars map[string]string) {
+	if err := os.MkdirAll(filepath.Join(testTemplateRepo, ".dotfiles"), os.ModePerm); err != nil {
+		panic(err)
+	}
+	for name, content := range vars {
+		writeFile(filepath.Join(testTemplateRepo, ".dotfiles", name), content)
+	}
+}
+
+func TestLoadTemplateData(t *testing.T) {
+	createTemplateRepo(map[string]string{
+		"vars.json":                        `{"email": "foo@example.com", "name": "foo"}`,
+		"vars_" + runtime.GOOS + ".yaml":   "email: bar@example.com\n",
+		"vars_profile__test_template.toml": `name = "baz"`,
+	})
+	defer os.RemoveAll(testTemplateRepo)
+	os.Setenv("DOTFILES_PROFILE", "_test_template")
+	defer os.Unsetenv("DOTFILES_PROFILE")
+
+	d, err := loadTemplateData(getcwd().Join(testTemplateRepo), runtime.GOOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Vars["email"] != "bar@example.com" {
+		t.Errorf("Platform specific variable should override: %v", d.Vars)
+	}
+	if d.Vars["name"] != "baz" {
+		t.Errorf("Profile specific variable should override: %v", d.Vars)
+	}
+	if d.OS != runtime.GOOS || d.Profile != "_test_template" {
+		t.Errorf("Facts are unexpected: %+v", d)
+	}
+	if d.Env["DOTFILES_PROFILE"] != "_test_template" {
+		t.Errorf("Environment variables should be available: %v", d.Env["DOTFILES_PROFILE"])
+	}
+}
+
+func TestTemplateHomeWithAlternateHome(t *testing.T) {
+	createTemplateRepo(nil)
+	defer os.RemoveAll(testTemplateRepo)
+	if err := SetTarget("/home/foo", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTarget("", "")
+
+	d, err := loadTemplateData(getcwd().Join(testTemplateRepo), runtime.GOOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Home != filepath.FromSlash("/home/foo") {
+		t.Errorf("Home should be the alternate home directory but got '%s'", d.Home)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	createTemplateRepo(map[string]string{"vars.json": `{"email": "foo@example.com"}`})
+	defer os.RemoveAll(testTemplateRepo)
+	src := filepath.Join(testTemplateRepo, "gitconfig.tmpl")
+	r := &templateRenderer{repo: getcwd().Join(testTemplateRepo)}
+
+	writeFile(src, "email = {{ .Vars.email }}\nos = {{ .OS }}\n")
+	b, err := r.render(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "email = foo@example.com\nos = " + runtime.GOOS + "\n"; string(b) != want {
+		t.Errorf("Wanted %q but got %q", want, string(b))
+	}
+
+	for _, input := range []string{"{{ .Vars.unknown }}", "{{ .Unknown }}", "{{ oops"} {
+		writeFile(src, input)
+		if _, err := r.render(src); err == nil {
+			t.Errorf("Rendering %q should cause an error", input)
+		}
+	}
+}
+
+func TestLinkTemplate(t *testing.T) {
+	resetManifest()
+	defer resetManifest()
+	createTemplateRepo(map[string]string{"vars.json": `{"email": "foo@example.com"}`})
+	defer os.RemoveAll(testTemplateRepo)
+	repo := getcwd().Join(testTemplateRepo)
+	writeFile(filepath.Join(testTemplateRepo, "gitconfig.tmpl"), "email = {{ .Vars.email }}\n")
+	dst := filepath.Join(testTemplateRepo, "_gitconfig")
+
+	m := Mappings{
+		"gitconfig.tmpl": []Destination{{Path: repo.Join("_gitconfig"), Origin: "mappings.json"}},
+	}
+
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if have := readFile(dst); have != "email = foo@example.com\n" {
+		t.Fatalf("Template should be rendered: %q", have)
+	}
+
+	// Rendered file becomes stale when variables are changed
+	writeFile(filepath.Join(testTemplateRepo, ".dotfiles", "vars.json"), `{"email": "bar@example.com"}`)
+	sts, err := m.Status(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusOutdated {
+		t.Fatalf("Rendered file should be outdated: %+v", sts)
+	}
+
+	if err := m.CreateAllLinks(repo, LinkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if have := readFile(dst); have != "email = bar@example.com\n" {
+		t.Fatalf("Stale file should be rendered again: %q", have)
+	}
+
+	sts, err = m.Status(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Status != StatusLinked {
+		t.Fatalf("Rendered file should be linked: %+v", sts)
+	}
+
+	if err := m.UnlinkAll(repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		t.Fatalf("Rendered file should be removed by clean")
+	}
+}
+
+func TestExpandTemplateDestination(t *testing.T) {
+	if err := os.MkdirAll(filepath.Join(testTemplateRepo, "config"), os.ModePerm); err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(testTemplateRepo)
+	writeFile(filepath.Join(testTemplateRepo, "config", "foo.conf.tmpl"), "")
+	writeFile(filepath.Join(testTemplateRepo, "config", "bar.conf.tmpl"), "")
+	repo := getcwd().Join(testTemplateRepo)
+	home := getcwd().Join("_home")
+
+	m := Mappings{
+		"config/*": []Destination{{Path: home.Join(".config"), Origin: "mappings.json", Dir: true}},
+		"config/bar.conf.tmpl": []Destination{
+			{Path: home.Join("bar.conf.tmpl"), Origin: "mappings.json", Mode: ModeCopy},
+		},
+	}
+	expanded, err := m.expand(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := expanded["config/foo.conf.tmpl"]
+	if len(ds) != 1 || ds[0].Mode != ModeTemplate || ds[0].Path.String() != home.Join(".config", "foo.conf").String() {
+		t.Errorf("Extension of template should be removed from destination: %+v", ds)
+	}
+	ds = expanded["config/bar.conf.tmpl"]
+	if len(ds) != 1 || ds[0].Mode != ModeCopy {
+		t.Errorf("Explicit mode should take precedence: %+v", ds)
+	}
+}